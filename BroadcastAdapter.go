@@ -15,20 +15,90 @@ type BroadcastAdaptor interface {
 	Send(ignore Socket, room, message string, args ...interface{}) error
 }
 
+// BroadcastOptions select the sockets of a broadcast. Room names include the namespace, see BroadcastOperator.
+type BroadcastOptions struct {
+	Rooms     []string // Rooms selects the sockets in any of the rooms, each socket once.
+	Except    []string // Except leaves out the sockets in any of the rooms.
+	ExceptIds []string // ExceptIds leaves out the sockets with the ids.
+
+	// Data only selects the sockets whose Socket.Get returns the values of its keys, compared as JSON.
+	Data map[string]interface{}
+
+	Volatile bool // Volatile drops the message for the sockets which can't get it at once, see Socket.Volatile.
+}
+
+// RoomsBroadcastAdaptor is the BroadcastAdaptor which can send to several rooms at once. Adaptors
+// without it only support a BroadcastOperator with one room and no exception but the sender.
+type RoomsBroadcastAdaptor interface {
+	BroadcastAdaptor
+
+	// SendTo sends the message with args to the sockets selected by opts.
+	SendTo(opts BroadcastOptions, message string, args ...interface{}) error
+}
+
+// ClusterBroadcastAdaptor is the BroadcastAdaptor which can act on the sockets selected by opts on
+// every server, see BroadcastOperator. The selected sockets are of one namespace, so rooms are the
+// names passed to Socket.Join, without the namespace.
+type ClusterBroadcastAdaptor interface {
+	BroadcastAdaptor
+
+	// SocketsJoin makes the sockets selected by opts join rooms.
+	SocketsJoin(opts BroadcastOptions, rooms ...string) error
+
+	// SocketsLeave makes the sockets selected by opts leave rooms.
+	SocketsLeave(opts BroadcastOptions, rooms ...string) error
+
+	// DisconnectSockets disconnects the sockets selected by opts.
+	DisconnectSockets(opts BroadcastOptions) error
+}
+
+// IntrospectBroadcastAdaptor is the BroadcastAdaptor which can list its rooms. Room names include the
+// namespace, see Namespace.ListOfRooms for the rooms of one namespace.
+type IntrospectBroadcastAdaptor interface {
+	BroadcastAdaptor
+
+	// ListOfRooms returns the names of the rooms with sockets.
+	ListOfRooms() ([]string, error)
+
+	// NumberOfRooms returns the number of rooms with sockets.
+	NumberOfRooms() (int, error)
+
+	// SocketsInRoom returns the ids of the sockets in room.
+	SocketsInRoom(room string) ([]string, error)
+
+	// NumberInRoom returns the number of sockets in room.
+	NumberInRoom(room string) (int, error)
+
+	// RoomsOfSocket returns the rooms joined by the socket with id.
+	RoomsOfSocket(id string) ([]string, error)
+}
+
 var newBroadcast = newBroadcastDefault
 
 // Broadcast is a set of "room" each with a set of Socket
 type broadcast struct {
 	roomSet       map[string]map[string]Socket
+	metrics       Metrics
 	broadcastLock sync.RWMutex
 }
 
 func newBroadcastDefault() BroadcastAdaptor {
 	return &broadcast{
 		roomSet: make(map[string]map[string]Socket),
+		metrics: nopMetrics{},
 	}
 }
 
+// Set the metrics which get the number of sockets of each broadcast.
+func (b *broadcast) SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	b.broadcastLock.Lock()
+	defer b.broadcastLock.Unlock()
+	b.metrics = m
+}
+
 // Join into a room
 func (b *broadcast) Join(room string, socket Socket) error {
 	b.broadcastLock.Lock()
@@ -47,6 +117,7 @@ func (b *broadcast) Leave(room string, socket Socket) error {
 	b.broadcastLock.Lock()
 	sockets, ok := b.roomSet[room]
 	if !ok {
+		b.broadcastLock.Unlock()
 		return nil
 	}
 	delete(sockets, socket.Id())
@@ -62,43 +133,122 @@ func (b *broadcast) Leave(room string, socket Socket) error {
 // Perform a brodcast send to all the sockets in a "room" except the ignored socket.
 // Brodcast send to all with ignore == nil.
 func (b *broadcast) Send(ignore Socket, room, message string, args ...interface{}) error {
+	opts := BroadcastOptions{
+		Rooms: []string{room},
+	}
+	if ignore != nil {
+		opts.ExceptIds = []string{ignore.Id()}
+	}
+	return b.SendTo(opts, message, args...)
+}
+
+// Send to the sockets in any of opts.Rooms, each once, but the ones in opts.Except, with an id in opts.ExceptIds
+// or without the values of opts.Data.
+// The sockets are picked under the lock, and sent to after it's released so rooms can change meanwhile.
+func (b *broadcast) SendTo(opts BroadcastOptions, message string, args ...interface{}) error {
+	selected := b.sockets(opts)
+	for _, s := range selected {
+		if opts.Volatile {
+			s.Volatile().Emit(message, args...)
+		} else {
+			s.Emit(message, args...)
+		}
+	}
+	b.metrics.Broadcasted(len(selected))
+	return nil
+}
+
+// Make the sockets selected by opts join rooms.
+func (b *broadcast) SocketsJoin(opts BroadcastOptions, rooms ...string) error {
+	for _, s := range b.sockets(opts) {
+		for _, room := range rooms {
+			if err := s.Join(room); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Make the sockets selected by opts leave rooms.
+func (b *broadcast) SocketsLeave(opts BroadcastOptions, rooms ...string) error {
+	for _, s := range b.sockets(opts) {
+		for _, room := range rooms {
+			if err := s.Leave(room); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Disconnect the sockets selected by opts.
+func (b *broadcast) DisconnectSockets(opts BroadcastOptions) error {
+	for _, s := range b.sockets(opts) {
+		if err := s.Disconnect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sockets returns the sockets selected by opts. Join and Leave take broadcastLock, so the sockets are
+// used after it's released.
+func (b *broadcast) sockets(opts BroadcastOptions) []Socket {
 	b.broadcastLock.RLock()
 	defer b.broadcastLock.RUnlock()
-	sockets := b.roomSet[room]
-	for id, s := range sockets {
-		if ignore != nil && ignore.Id() == id {
-			continue
+	selected := b.selectSockets(opts)
+	ret := make([]Socket, 0, len(selected))
+	for _, s := range selected {
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// selectSockets returns the sockets selected by opts, the caller holds broadcastLock.
+func (b *broadcast) selectSockets(opts BroadcastOptions) map[string]Socket {
+	ret := make(map[string]Socket)
+	for _, room := range opts.Rooms {
+		for id, s := range b.roomSet[room] {
+			ret[id] = s
 		}
-		s.Emit(message, args...)
 	}
-	return nil
+	for _, room := range opts.Except {
+		for id := range b.roomSet[room] {
+			delete(ret, id)
+		}
+	}
+	for _, id := range opts.ExceptIds {
+		delete(ret, id)
+	}
+	if len(opts.Data) > 0 {
+		for id, s := range ret {
+			if !matchData(s, opts.Data) {
+				delete(ret, id)
+			}
+		}
+	}
+	return ret
 }
 
 // return the number of connections in a specified room
 func (b *broadcast) NumberInRoom(room string) (rv int, err error) {
 	b.broadcastLock.RLock()
 	defer b.broadcastLock.RUnlock()
-	sockets := b.roomSet[room]
-	rv = 0
-	for _, _ = range sockets {
-		rv++
-	}
+	rv = len(b.roomSet[room])
 	return
 }
 
 // return the number of rooms
-func (b *broadcast) NumberOfRooms(room string) (rv int, err error) {
+func (b *broadcast) NumberOfRooms() (rv int, err error) {
 	b.broadcastLock.RLock()
 	defer b.broadcastLock.RUnlock()
-	rv = 0
-	for _, _ = range b.roomSet {
-		rv++
-	}
+	rv = len(b.roomSet)
 	return
 }
 
 // return the names of the rooms as a slice of strings
-func (b *broadcast) ListOfRooms(room string) (rv []string, err error) {
+func (b *broadcast) ListOfRooms() (rv []string, err error) {
 	b.broadcastLock.RLock()
 	defer b.broadcastLock.RUnlock()
 	for room := range b.roomSet {
@@ -106,3 +256,25 @@ func (b *broadcast) ListOfRooms(room string) (rv []string, err error) {
 	}
 	return
 }
+
+// return the ids of the sockets in a specified room
+func (b *broadcast) SocketsInRoom(room string) (rv []string, err error) {
+	b.broadcastLock.RLock()
+	defer b.broadcastLock.RUnlock()
+	for id := range b.roomSet[room] {
+		rv = append(rv, id)
+	}
+	return
+}
+
+// return the rooms joined by the socket with a specified id
+func (b *broadcast) RoomsOfSocket(id string) (rv []string, err error) {
+	b.broadcastLock.RLock()
+	defer b.broadcastLock.RUnlock()
+	for room, sockets := range b.roomSet {
+		if _, ok := sockets[id]; ok {
+			rv = append(rv, room)
+		}
+	}
+	return
+}
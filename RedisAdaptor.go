@@ -0,0 +1,433 @@
+package socketio
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+)
+
+// RedisOptions are the options of NewRedisAdaptor.
+type RedisOptions struct {
+	Addr     string // Addr of the redis server. Default is "localhost:6379".
+	Password string // Password sent with AUTH if not empty.
+	Channel  string // Channel of pub/sub shared by all servers. Default is "socket.io".
+	NodeId   string // NodeId tags the messages of this server, so they aren't sent twice. Default is a random id.
+}
+
+// RedisAdaptor is the BroadcastAdaptor for running several servers. Sockets join rooms on their own
+// server, and every Send is published to the redis channel, so the other servers send it to their
+// sockets in the room too.
+//
+// The args are sent to other servers as JSON, so messages with binary args, []byte or Attachment, are
+// only sent to local sockets.
+// Rooms listed by ListOfRooms and the like are the ones of local sockets.
+type RedisAdaptor struct {
+	*broadcast
+	opts      RedisOptions
+	pub       *redisConn
+	pubLock   sync.Mutex
+	sub       *redisConn
+	subLock   sync.Mutex
+	closed    bool
+	closeChan chan struct{}
+}
+
+// redisMessage is the message published for a Send or SendTo, or with a Type for the other operations
+// of ClusterBroadcastAdaptor.
+type redisMessage struct {
+	Node    string            `json:"node"`
+	Type    string            `json:"type,omitempty"`
+	Opts    BroadcastOptions  `json:"opts"`
+	Rooms   []string          `json:"rooms,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Args    []json.RawMessage `json:"args,omitempty"`
+}
+
+// The types of redisMessage, an empty type is a SendTo.
+const (
+	redisJoin       = "join"
+	redisLeave      = "leave"
+	redisDisconnect = "disconnect"
+)
+
+// NewRedisAdaptor connects to the redis server and subscribes the channel. If opts is nil, default
+// options are used.
+func NewRedisAdaptor(opts *RedisOptions) (*RedisAdaptor, error) {
+	ret := &RedisAdaptor{
+		broadcast: newBroadcastDefault().(*broadcast),
+		closeChan: make(chan struct{}),
+	}
+	if opts != nil {
+		ret.opts = *opts
+	}
+	if ret.opts.Addr == "" {
+		ret.opts.Addr = "localhost:6379"
+	}
+	if ret.opts.Channel == "" {
+		ret.opts.Channel = "socket.io"
+	}
+	if ret.opts.NodeId == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		ret.opts.NodeId = hex.EncodeToString(b)
+	}
+
+	sub, err := ret.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	pub, err := dialRedis(ret.opts.Addr, ret.opts.Password)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	ret.sub = sub
+	ret.pub = pub
+	go ret.loop(sub)
+	return ret, nil
+}
+
+// NodeId returns the id which tags the messages of this server.
+func (a *RedisAdaptor) NodeId() string {
+	return a.opts.NodeId
+}
+
+// Send sends the message to the sockets of this server in the room, and publishes it to the others.
+func (a *RedisAdaptor) Send(ignore Socket, room, message string, args ...interface{}) error {
+	opts := BroadcastOptions{
+		Rooms: []string{room},
+	}
+	if ignore != nil {
+		opts.ExceptIds = []string{ignore.Id()}
+	}
+	return a.SendTo(opts, message, args...)
+}
+
+// SendTo sends the message to the sockets of this server selected by opts, and publishes it to the others
+// unless it has binary args.
+func (a *RedisAdaptor) SendTo(opts BroadcastOptions, message string, args ...interface{}) error {
+	a.broadcast.SendTo(opts, message, args...)
+	if hasBinary(args) {
+		return nil
+	}
+
+	m := redisMessage{
+		Opts:    opts,
+		Message: message,
+		Args:    make([]json.RawMessage, len(args)),
+	}
+	for i, arg := range args {
+		b, err := json.Marshal(arg)
+		if err != nil {
+			return err
+		}
+		m.Args[i] = b
+	}
+	return a.publishMessage(m)
+}
+
+// hasBinary returns true if args have a []byte or an Attachment, which can't be published as JSON.
+func hasBinary(args []interface{}) bool {
+	for _, arg := range args {
+		if _, ok := arg.([]byte); ok {
+			return true
+		}
+		if len(encodeAttachments(arg)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SocketsJoin makes the sockets of this server selected by opts join rooms, and publishes it to the others.
+func (a *RedisAdaptor) SocketsJoin(opts BroadcastOptions, rooms ...string) error {
+	if err := a.broadcast.SocketsJoin(opts, rooms...); err != nil {
+		return err
+	}
+	return a.publishMessage(redisMessage{
+		Type:  redisJoin,
+		Opts:  opts,
+		Rooms: rooms,
+	})
+}
+
+// SocketsLeave makes the sockets of this server selected by opts leave rooms, and publishes it to the others.
+func (a *RedisAdaptor) SocketsLeave(opts BroadcastOptions, rooms ...string) error {
+	if err := a.broadcast.SocketsLeave(opts, rooms...); err != nil {
+		return err
+	}
+	return a.publishMessage(redisMessage{
+		Type:  redisLeave,
+		Opts:  opts,
+		Rooms: rooms,
+	})
+}
+
+// DisconnectSockets disconnects the sockets of this server selected by opts, and publishes it to the others.
+func (a *RedisAdaptor) DisconnectSockets(opts BroadcastOptions) error {
+	if err := a.broadcast.DisconnectSockets(opts); err != nil {
+		return err
+	}
+	return a.publishMessage(redisMessage{
+		Type: redisDisconnect,
+		Opts: opts,
+	})
+}
+
+// publishMessage publishes m tagged with the node id.
+func (a *RedisAdaptor) publishMessage(m redisMessage) error {
+	m.Node = a.opts.NodeId
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return a.publish(string(b))
+}
+
+// Close unsubscribes the channel and closes the connections to redis.
+func (a *RedisAdaptor) Close() error {
+	a.subLock.Lock()
+	if a.closed {
+		a.subLock.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.closeChan)
+	sub := a.sub
+	a.subLock.Unlock()
+	sub.Close()
+
+	a.pubLock.Lock()
+	defer a.pubLock.Unlock()
+	return a.pub.Close()
+}
+
+// publish publishes payload to the channel, dialing again once if the connection is broken.
+func (a *RedisAdaptor) publish(payload string) error {
+	a.pubLock.Lock()
+	defer a.pubLock.Unlock()
+	_, err := a.pub.Do("PUBLISH", a.opts.Channel, payload)
+	if _, ok := err.(redisError); ok || err == nil {
+		return err
+	}
+	select {
+	case <-a.closeChan:
+		return err
+	default:
+	}
+	a.pub.Close()
+	pub, err := dialRedis(a.opts.Addr, a.opts.Password)
+	if err != nil {
+		return err
+	}
+	a.pub = pub
+	_, err = a.pub.Do("PUBLISH", a.opts.Channel, payload)
+	return err
+}
+
+func (a *RedisAdaptor) subscribe() (*redisConn, error) {
+	c, err := dialRedis(a.opts.Addr, a.opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Do("SUBSCRIBE", a.opts.Channel); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// loop sends the messages of other servers to the local sockets, and subscribes again when the
+// connection is lost.
+func (a *RedisAdaptor) loop(c *redisConn) {
+	for {
+		for {
+			reply, err := c.Receive()
+			if err != nil {
+				break
+			}
+			a.onReply(reply)
+		}
+		c.Close()
+
+		for {
+			select {
+			case <-a.closeChan:
+				return
+			case <-time.After(time.Second):
+			}
+			var err error
+			if c, err = a.subscribe(); err == nil {
+				break
+			}
+		}
+		a.subLock.Lock()
+		if a.closed {
+			a.subLock.Unlock()
+			c.Close()
+			return
+		}
+		a.sub = c
+		a.subLock.Unlock()
+	}
+}
+
+func (a *RedisAdaptor) onReply(reply interface{}) {
+	r, ok := reply.([]interface{})
+	if !ok || len(r) != 3 {
+		return
+	}
+	if kind, _ := r[0].(string); kind != "message" {
+		return
+	}
+	payload, ok := r[2].(string)
+	if !ok {
+		return
+	}
+	var m redisMessage
+	if err := json.Unmarshal([]byte(payload), &m); err != nil {
+		return
+	}
+	if m.Node == a.opts.NodeId {
+		return
+	}
+	switch m.Type {
+	case redisJoin:
+		a.broadcast.SocketsJoin(m.Opts, m.Rooms...)
+	case redisLeave:
+		a.broadcast.SocketsLeave(m.Opts, m.Rooms...)
+	case redisDisconnect:
+		a.broadcast.DisconnectSockets(m.Opts)
+	default:
+		args := make([]interface{}, len(m.Args))
+		for i, arg := range m.Args {
+			args[i] = arg
+		}
+		a.broadcast.SendTo(m.Opts, m.Message, args...)
+	}
+}
+
+// redisError is the error reply of redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisConn is a connection speaking the redis protocol, just enough for pub/sub.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRedis(addr, password string) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	ret := &redisConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+	if password != "" {
+		if _, err := ret.Do("AUTH", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends the command args and returns the reply. An error reply is returned as redisError.
+func (c *redisConn) Do(args ...string) (interface{}, error) {
+	if err := c.Send(args...); err != nil {
+		return nil, err
+	}
+	return c.Receive()
+}
+
+// Send sends the command args without reading the reply.
+func (c *redisConn) Send(args ...string) error {
+	w := bufio.NewWriter(c.conn)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return w.Flush()
+}
+
+// Receive reads a reply. Bulk strings are returned as string, integers as int64 and arrays as
+// []interface{}. An error reply is returned as redisError.
+func (c *redisConn) Receive() (interface{}, error) {
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("invalid redis reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return redisError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		ret := make([]interface{}, n)
+		for i := range ret {
+			if ret[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply type %q", kind)
+}
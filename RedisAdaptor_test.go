@@ -0,0 +1,233 @@
+package socketio
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRedis is a redis server which only knows SUBSCRIBE, PUBLISH, SET with PX, GET and DEL.
+type fakeRedis struct {
+	listener    net.Listener
+	subscribers map[string][]*redisConn
+	values      map[string]string
+	expiries    map[string]time.Time
+	lock        sync.Mutex
+}
+
+func newFakeRedis() (*fakeRedis, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	ret := &fakeRedis{
+		listener:    l,
+		subscribers: make(map[string][]*redisConn),
+		values:      make(map[string]string),
+		expiries:    make(map[string]time.Time),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ret.serve(&redisConn{conn: conn, r: bufio.NewReader(conn)})
+		}
+	}()
+	return ret, nil
+}
+
+func (f *fakeRedis) Addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) Close() error {
+	return f.listener.Close()
+}
+
+func (f *fakeRedis) serve(c *redisConn) {
+	defer c.Close()
+	for {
+		req, err := c.read()
+		if err != nil {
+			return
+		}
+		args, _ := req.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		channel, _ := args[1].(string)
+		switch args[0] {
+		case "SUBSCRIBE":
+			f.lock.Lock()
+			f.subscribers[channel] = append(f.subscribers[channel], c)
+			c.conn.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$" + strconv.Itoa(len(channel)) + "\r\n" + channel + "\r\n:1\r\n"))
+			f.lock.Unlock()
+		case "PUBLISH":
+			payload, _ := args[2].(string)
+			f.lock.Lock()
+			subscribers := f.subscribers[channel]
+			for _, s := range subscribers {
+				s.conn.Write([]byte("*3\r\n$7\r\nmessage\r\n$" + strconv.Itoa(len(channel)) + "\r\n" + channel + "\r\n$" + strconv.Itoa(len(payload)) + "\r\n" + payload + "\r\n"))
+			}
+			f.lock.Unlock()
+			c.conn.Write([]byte(":" + strconv.Itoa(len(subscribers)) + "\r\n"))
+		case "SET":
+			value, _ := args[2].(string)
+			f.lock.Lock()
+			f.values[channel] = value
+			delete(f.expiries, channel)
+			if len(args) == 5 && args[3] == "PX" {
+				ms, _ := strconv.Atoi(args[4].(string))
+				f.expiries[channel] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+			f.lock.Unlock()
+			c.conn.Write([]byte("+OK\r\n"))
+		case "GET":
+			f.lock.Lock()
+			value, ok := f.values[channel]
+			if expiry, set := f.expiries[channel]; set && time.Now().After(expiry) {
+				ok = false
+			}
+			f.lock.Unlock()
+			if !ok {
+				c.conn.Write([]byte("$-1\r\n"))
+				break
+			}
+			c.conn.Write([]byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"))
+		case "DEL":
+			f.lock.Lock()
+			_, ok := f.values[channel]
+			delete(f.values, channel)
+			f.lock.Unlock()
+			if ok {
+				c.conn.Write([]byte(":1\r\n"))
+			} else {
+				c.conn.Write([]byte(":0\r\n"))
+			}
+		default:
+			c.conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+	}
+}
+
+func TestRedisAdaptor(t *testing.T) {
+	Convey("Broadcast between two servers", t, func() {
+		redis, err := newFakeRedis()
+		So(err, ShouldBeNil)
+		defer redis.Close()
+
+		newNode := func() (*httptest.Server, *Server, *RedisAdaptor) {
+			adaptor, err := NewRedisAdaptor(&RedisOptions{Addr: redis.Addr()})
+			So(err, ShouldBeNil)
+			server, err := NewServer(nil)
+			So(err, ShouldBeNil)
+			server.SetAdaptor(adaptor)
+			server.On("connection", func(so Socket) {
+				so.Join("room")
+			})
+			server.On("shout", func(so Socket, msg string) {
+				so.BroadcastTo("room", "news", msg)
+			})
+			return httptest.NewServer(server), server, adaptor
+		}
+		h1, server1, adaptor1 := newNode()
+		defer h1.Close()
+		defer adaptor1.Close()
+		h2, _, adaptor2 := newNode()
+		defer h2.Close()
+		defer adaptor2.Close()
+		So(adaptor1.NodeId(), ShouldNotEqual, adaptor2.NodeId())
+
+		news1 := make(chan string, 10)
+		client1, err := Dial(h1.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client1.Close()
+		client1.On("news", func(msg string) {
+			news1 <- msg
+		})
+		news2 := make(chan string, 10)
+		client2, err := Dial(h2.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client2.Close()
+		client2.On("news", func(msg string) {
+			news2 <- msg
+		})
+		inRoom := func(a *RedisAdaptor) int {
+			n, _ := a.NumberInRoom(":room")
+			return n
+		}
+		for inRoom(adaptor1) == 0 || inRoom(adaptor2) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		Convey("from server", func() {
+			server1.BroadcastTo("room", "news", "hello")
+			So(<-news1, ShouldEqual, "hello")
+			So(<-news2, ShouldEqual, "hello")
+
+			time.Sleep(100 * time.Millisecond)
+			So(len(news1), ShouldEqual, 0)
+			So(len(news2), ShouldEqual, 0)
+		})
+
+		Convey("binary args to local sockets only", func() {
+			server1.BroadcastTo("room", "news", []byte("hi"))
+			So(<-news1, ShouldEqual, "aGk=")
+
+			So(hasBinary([]interface{}{"text", &HaveAttachment{A: &Attachment{}}}), ShouldBeTrue)
+			So(hasBinary([]interface{}{"text", json.RawMessage(`"hi"`)}), ShouldBeFalse)
+
+			server1.BroadcastTo("room", "news", "after")
+			So(<-news1, ShouldEqual, "after")
+			So(<-news2, ShouldEqual, "after")
+		})
+
+		Convey("join, leave and disconnect on every server", func() {
+			So(server1.In("room").SocketsJoin("vip"), ShouldBeNil)
+			for inRoom := func(a *RedisAdaptor) int {
+				n, _ := a.NumberInRoom(":vip")
+				return n
+			}; inRoom(adaptor1) == 0 || inRoom(adaptor2) == 0; {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(server1.To("vip").Emit("news", "vip"), ShouldBeNil)
+			So(<-news1, ShouldEqual, "vip")
+			So(<-news2, ShouldEqual, "vip")
+
+			So(server1.In("vip").SocketsLeave("room"), ShouldBeNil)
+			for inRoom(adaptor1) != 0 || inRoom(adaptor2) != 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			disconnected := make(chan bool, 2)
+			client1.On("disconnect", func() {
+				disconnected <- true
+			})
+			client2.On("disconnect", func() {
+				disconnected <- true
+			})
+			So(server1.In("vip").DisconnectSockets(), ShouldBeNil)
+			<-disconnected
+			<-disconnected
+		})
+
+		Convey("from socket", func() {
+			So(client1.Emit("shout", "hi"), ShouldBeNil)
+			So(<-news2, ShouldEqual, "hi")
+
+			time.Sleep(100 * time.Millisecond)
+			So(len(news1), ShouldEqual, 0)
+			So(len(news2), ShouldEqual, 0)
+		})
+	})
+}
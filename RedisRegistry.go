@@ -0,0 +1,146 @@
+package socketio
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisRegistryOptions are the options of NewRedisRegistry.
+type RedisRegistryOptions struct {
+	Addr     string        // Addr of the redis server. Default is "localhost:6379".
+	Password string        // Password sent with AUTH if not empty.
+	Prefix   string        // Prefix of the keys of the sessions. Default is "socket.io#sid#".
+	Expiry   time.Duration // Expiry of the sessions recorded by Register. Default is 85s.
+	PoolSize int           // PoolSize is the max number of idle connections kept. Default is 10.
+}
+
+// RedisRegistry is the engineio.SessionRegistry shared by servers through a redis server. The owner
+// of a session is stored at the key of the prefix and the session id, and expires unless the server
+// registers it again, which it does on every ping. Commands run on a pool of connections, so
+// concurrent handshakes and forwarded requests don't wait for each other.
+type RedisRegistry struct {
+	opts   RedisRegistryOptions
+	idle   []*redisConn
+	lock   sync.Mutex
+	closed bool
+}
+
+// NewRedisRegistry connects to the redis server. If opts is nil, default options are used.
+func NewRedisRegistry(opts *RedisRegistryOptions) (*RedisRegistry, error) {
+	ret := &RedisRegistry{}
+	if opts != nil {
+		ret.opts = *opts
+	}
+	if ret.opts.Addr == "" {
+		ret.opts.Addr = "localhost:6379"
+	}
+	if ret.opts.Prefix == "" {
+		ret.opts.Prefix = "socket.io#sid#"
+	}
+	if ret.opts.Expiry <= 0 {
+		ret.opts.Expiry = 85 * time.Second
+	}
+	if ret.opts.PoolSize <= 0 {
+		ret.opts.PoolSize = 10
+	}
+	conn, err := dialRedis(ret.opts.Addr, ret.opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	ret.idle = append(ret.idle, conn)
+	return ret, nil
+}
+
+func (r *RedisRegistry) Register(sid, node string) error {
+	return r.RegisterFor(sid, node, r.opts.Expiry)
+}
+
+// RegisterFor records node as the owner of session sid for ttl, see engineio.ExpiringRegistry.
+func (r *RedisRegistry) RegisterFor(sid, node string, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	_, err := r.do("SET", r.opts.Prefix+sid, node, "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+func (r *RedisRegistry) Lookup(sid string) (string, error) {
+	reply, err := r.do("GET", r.opts.Prefix+sid)
+	if err != nil {
+		return "", err
+	}
+	node, _ := reply.(string)
+	return node, nil
+}
+
+func (r *RedisRegistry) Unregister(sid string) error {
+	_, err := r.do("DEL", r.opts.Prefix+sid)
+	return err
+}
+
+// Close closes the connections to the redis server.
+func (r *RedisRegistry) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	for _, c := range r.idle {
+		c.Close()
+	}
+	r.idle = nil
+	return nil
+}
+
+// do sends the command args on a connection of the pool, dialing again once if the connection is
+// broken.
+func (r *RedisRegistry) do(args ...string) (interface{}, error) {
+	c, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.Do(args...)
+	if _, ok := err.(redisError); !ok && err != nil {
+		c.Close()
+		if c, err = dialRedis(r.opts.Addr, r.opts.Password); err != nil {
+			return nil, err
+		}
+		reply, err = c.Do(args...)
+		if _, ok := err.(redisError); !ok && err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	r.put(c)
+	return reply, err
+}
+
+// get takes an idle connection, or dials a new one if there is none.
+func (r *RedisRegistry) get() (*redisConn, error) {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return nil, net.ErrClosed
+	}
+	if n := len(r.idle); n > 0 {
+		c := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.lock.Unlock()
+		return c, nil
+	}
+	r.lock.Unlock()
+	return dialRedis(r.opts.Addr, r.opts.Password)
+}
+
+// put gives back the connection c, which is closed if the pool is full.
+func (r *RedisRegistry) put(c *redisConn) {
+	r.lock.Lock()
+	if r.closed || len(r.idle) >= r.opts.PoolSize {
+		r.lock.Unlock()
+		c.Close()
+		return
+	}
+	r.idle = append(r.idle, c)
+	r.lock.Unlock()
+}
@@ -0,0 +1,92 @@
+package socketio
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedisRegistry(t *testing.T) {
+	Convey("Register, look up and unregister sessions", t, func() {
+		redis, err := newFakeRedis()
+		So(err, ShouldBeNil)
+		defer redis.Close()
+
+		registry, err := NewRedisRegistry(&RedisRegistryOptions{Addr: redis.Addr()})
+		So(err, ShouldBeNil)
+		defer registry.Close()
+
+		node, err := registry.Lookup("a")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "")
+
+		So(registry.Register("a", "http://node1"), ShouldBeNil)
+		node, err = registry.Lookup("a")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "http://node1")
+		redis.lock.Lock()
+		So(redis.values["socket.io#sid#a"], ShouldEqual, "http://node1")
+		redis.lock.Unlock()
+
+		So(registry.Unregister("a"), ShouldBeNil)
+		So(registry.Unregister("a"), ShouldBeNil)
+		node, err = registry.Lookup("a")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "")
+	})
+	Convey("Expire sessions which aren't registered again", t, func() {
+		redis, err := newFakeRedis()
+		So(err, ShouldBeNil)
+		defer redis.Close()
+
+		registry, err := NewRedisRegistry(&RedisRegistryOptions{Addr: redis.Addr(), Expiry: 100 * time.Millisecond})
+		So(err, ShouldBeNil)
+		defer registry.Close()
+
+		So(registry.Register("a", "http://node1"), ShouldBeNil)
+		So(registry.RegisterFor("b", "http://node1", time.Minute), ShouldBeNil)
+		time.Sleep(150 * time.Millisecond)
+		node, err := registry.Lookup("a")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "")
+		node, err = registry.Lookup("b")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "http://node1")
+	})
+
+	Convey("Serve concurrent commands on a pool", t, func() {
+		redis, err := newFakeRedis()
+		So(err, ShouldBeNil)
+		defer redis.Close()
+
+		registry, err := NewRedisRegistry(&RedisRegistryOptions{Addr: redis.Addr(), PoolSize: 2})
+		So(err, ShouldBeNil)
+		defer registry.Close()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- registry.Register(strconv.Itoa(i), "http://node1")
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+		registry.lock.Lock()
+		idle := len(registry.idle)
+		registry.lock.Unlock()
+		So(idle, ShouldBeBetweenOrEqual, 1, 2)
+
+		registry.Close()
+		So(registry.Register("a", "http://node1"), ShouldResemble, net.ErrClosed)
+	})
+}
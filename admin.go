@@ -0,0 +1,414 @@
+package socketio
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+)
+
+// AdminOptions configures the admin namespace, see Server.Instrument.
+type AdminOptions struct {
+	Namespace     string                               // Namespace of the dashboard. Default is "/admin".
+	Username      string                               // Username the dashboard connects with.
+	Password      string                               // Password the dashboard connects with.
+	Authenticate  func(username, password string) bool // Authenticate checks the credentials instead of Username and Password.
+	ReadOnly      bool                                 // ReadOnly leaves out joining, leaving, disconnecting and emitting.
+	ServerId      string                               // ServerId names this server in the dashboard. Default is hostname#pid.
+	StatsInterval time.Duration                        // StatsInterval is the period of the server stats. Default is 2s.
+}
+
+// InvalidCredentialsError rejects the admin connections with wrong credentials.
+var InvalidCredentialsError = &ConnectError{Message: "invalid credentials"}
+
+// admin serves the socket.io Admin UI protocol on its namespace. It watches the sockets of the
+// other namespaces, and sends their events and the server stats to the dashboards.
+type admin struct {
+	server  *Server
+	nsp     *namespace
+	opts    AdminOptions
+	started time.Time
+	events  map[adminEventKey]int
+	lock    sync.Mutex
+	done    chan struct{}
+}
+
+// adminEventKey is the second, type and subtype of aggregated events.
+type adminEventKey struct {
+	timestamp int64
+	typ       string
+	subType   string
+}
+
+// Instrument opens the admin namespace for the socket.io Admin UI dashboard. Dashboards connect with
+// the credentials of opts, then list the namespaces, sockets and rooms of this server, and unless
+// opts.ReadOnly, make sockets join or leave rooms, disconnect them or emit to them. Sockets in rooms
+// are reached on every server through the adaptor, see ClusterBroadcastAdaptor.
+func (s *Server) Instrument(opts AdminOptions) error {
+	if opts.Authenticate == nil {
+		if opts.Username == "" || opts.Password == "" {
+			return errors.New("admin needs credentials")
+		}
+		username, password := []byte(opts.Username), []byte(opts.Password)
+		opts.Authenticate = func(u, p string) bool {
+			ok := subtle.ConstantTimeCompare([]byte(u), username)
+			ok &= subtle.ConstantTimeCompare([]byte(p), password)
+			return ok == 1
+		}
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "/admin"
+	}
+	if opts.ServerId == "" {
+		hostname, _ := os.Hostname()
+		opts.ServerId = fmt.Sprintf("%s#%d", hostname, os.Getpid())
+	}
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = 2 * time.Second
+	}
+	a := &admin{
+		server:  s,
+		opts:    opts,
+		started: time.Now(),
+		events:  make(map[adminEventKey]int),
+		done:    make(chan struct{}),
+	}
+	s.lock.Lock()
+	if s.admin != nil {
+		s.lock.Unlock()
+		return errors.New("admin is already instrumented")
+	}
+	s.admin = a
+	s.lock.Unlock()
+
+	a.nsp = s.Of(opts.Namespace).(*namespace)
+	a.nsp.Use(a.authenticate)
+	a.nsp.On("connection", a.onConnect)
+	if !opts.ReadOnly {
+		a.nsp.On("join", a.join)
+		a.nsp.On("leave", a.leave)
+		a.nsp.On("_disconnect", a.disconnect)
+		a.nsp.Handle("emit", a.emit)
+	}
+	s.namespace.watch.set(a)
+	go a.loop()
+	return nil
+}
+
+func (a *admin) authenticate(so Socket, next func(error)) {
+	username, _ := so.Auth()["username"].(string)
+	password, _ := so.Auth()["password"].(string)
+	if !a.opts.Authenticate(username, password) {
+		next(InvalidCredentialsError)
+		return
+	}
+	next(nil)
+}
+
+func (a *admin) features() []string {
+	if a.opts.ReadOnly {
+		return []string{"AGGREGATED_EVENTS"}
+	}
+	return []string{"EMIT", "JOIN", "LEAVE", "DISCONNECT", "MJOIN", "MLEAVE", "MDISCONNECT", "AGGREGATED_EVENTS"}
+}
+
+func (a *admin) onConnect(so Socket) {
+	so.Emit("config", map[string]interface{}{
+		"supportedFeatures": a.features(),
+	})
+	sockets := make([]map[string]interface{}, 0)
+	for _, n := range a.server.namespace.all() {
+		if n == a.nsp {
+			continue
+		}
+		for _, s := range n.Sockets() {
+			sockets = append(sockets, serializeSocket(s.(*socket)))
+		}
+	}
+	so.Emit("all_sockets", sockets)
+}
+
+// each calls f with the socket of namespace nsp whose id is filter. It returns false if there is no
+// such socket, then filter is a room.
+func (a *admin) each(nsp, filter string, f func(s *socket)) bool {
+	n := a.server.namespace.get(nsp)
+	if n == nil {
+		return true
+	}
+	n.lock.RLock()
+	s, ok := n.sockets[filter]
+	n.lock.RUnlock()
+	if ok {
+		f(s)
+	}
+	return ok
+}
+
+// operator returns the operator selecting the sockets of namespace nsp in room filter, or every
+// socket without filter.
+func (a *admin) operator(nsp, filter string) *BroadcastOperator {
+	n := a.server.namespace.get(nsp)
+	if n == nil {
+		return nil
+	}
+	ret := n.operator(nil)
+	if filter != "" {
+		ret = ret.In(filter)
+	}
+	return ret
+}
+
+func (a *admin) join(nsp, room, filter string) {
+	if a.each(nsp, filter, func(s *socket) { s.Join(room) }) {
+		return
+	}
+	if o := a.operator(nsp, filter); o != nil {
+		o.SocketsJoin(room)
+	}
+}
+
+func (a *admin) leave(nsp, room, filter string) {
+	if a.each(nsp, filter, func(s *socket) { s.Leave(room) }) {
+		return
+	}
+	if o := a.operator(nsp, filter); o != nil {
+		o.SocketsLeave(room)
+	}
+}
+
+// disconnect disconnects the selected sockets, or closes the connection of the socket filter if close.
+func (a *admin) disconnect(nsp string, close bool, filter string) {
+	if a.each(nsp, filter, func(s *socket) {
+		if close {
+			s.conn.disconnectAll()
+		} else {
+			s.Disconnect()
+		}
+	}) {
+		return
+	}
+	if o := a.operator(nsp, filter); o != nil {
+		o.DisconnectSockets()
+	}
+}
+
+// emit takes the namespace, the filter and the message, then the args to emit, which are passed on
+// as raw JSON.
+func (a *admin) emit(so Socket, _ string, raw [][]byte) error {
+	if len(raw) < 3 {
+		return nil
+	}
+	var nsp, filter, message string
+	for i, v := range []*string{&nsp, &filter, &message} {
+		if err := json.Unmarshal(raw[i], v); err != nil {
+			return err
+		}
+	}
+	args := make([]interface{}, 0, len(raw)-3)
+	for _, r := range raw[3:] {
+		args = append(args, json.RawMessage(r))
+	}
+	if a.each(nsp, filter, func(s *socket) { s.Emit(message, args...) }) {
+		return nil
+	}
+	if o := a.operator(nsp, filter); o != nil {
+		o.Emit(message, args...)
+	}
+	return nil
+}
+
+// loop sends the server stats to the dashboards until stop.
+func (a *admin) loop() {
+	ticker := time.NewTicker(a.opts.StatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.nsp.operator(nil).Emit("server_stats", a.stats())
+		case <-a.done:
+			return
+		}
+	}
+}
+
+func (a *admin) stop() {
+	close(a.done)
+}
+
+// stats returns the server stats, with the events aggregated since the last stats.
+func (a *admin) stats() map[string]interface{} {
+	a.lock.Lock()
+	events := make([]map[string]interface{}, 0, len(a.events))
+	for key, count := range a.events {
+		event := map[string]interface{}{
+			"timestamp": key.timestamp,
+			"type":      key.typ,
+			"count":     count,
+		}
+		if key.subType != "" {
+			event["subType"] = key.subType
+		}
+		events = append(events, event)
+	}
+	a.events = make(map[adminEventKey]int)
+	a.lock.Unlock()
+
+	clients, polling := 0, 0
+	a.server.lock.Lock()
+	for c := range a.server.conns {
+		clients++
+		if transport(c) == "polling" {
+			polling++
+		}
+	}
+	a.server.lock.Unlock()
+
+	namespaces := make([]map[string]interface{}, 0)
+	for _, n := range a.server.namespace.all() {
+		if n == a.nsp {
+			continue
+		}
+		n.lock.RLock()
+		count := len(n.sockets)
+		n.lock.RUnlock()
+		namespaces = append(namespaces, map[string]interface{}{
+			"name":         nspName(n),
+			"socketsCount": count,
+		})
+	}
+	return map[string]interface{}{
+		"serverId":            a.opts.ServerId,
+		"hostname":            strings.SplitN(a.opts.ServerId, "#", 2)[0],
+		"pid":                 os.Getpid(),
+		"uptime":              time.Since(a.started).Seconds(),
+		"clientsCount":        clients,
+		"pollingClientsCount": polling,
+		"aggregatedEvents":    events,
+		"namespaces":          namespaces,
+	}
+}
+
+// record counts n events of typ in the current second.
+func (a *admin) record(typ, subType string, n int) {
+	key := adminEventKey{
+		timestamp: time.Now().Truncate(time.Second).UnixNano() / int64(time.Millisecond),
+		typ:       typ,
+		subType:   subType,
+	}
+	a.lock.Lock()
+	a.events[key] += n
+	a.lock.Unlock()
+}
+
+// observer is told about the sockets and packets of a server.
+type observer interface {
+	socketConnected(s *socket)
+	socketDisconnected(s *socket)
+	roomJoined(s *socket, room string)
+	roomLeft(s *socket, room string)
+	packetReceived(bytes int)
+	packetSent(bytes int)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) socketConnected(*socket)    {}
+func (nopObserver) socketDisconnected(*socket) {}
+func (nopObserver) roomJoined(*socket, string) {}
+func (nopObserver) roomLeft(*socket, string)   {}
+func (nopObserver) packetReceived(int)         {}
+func (nopObserver) packetSent(int)             {}
+
+func (a *admin) socketConnected(s *socket) {
+	if s.nsp == a.nsp {
+		return
+	}
+	a.record("connection", nspName(s.nsp), 1)
+	a.nsp.operator(nil).Emit("socket_connected", serializeSocket(s), nspName(s.nsp))
+}
+
+func (a *admin) socketDisconnected(s *socket) {
+	if s.nsp == a.nsp {
+		return
+	}
+	a.record("disconnection", nspName(s.nsp), 1)
+	a.nsp.operator(nil).Emit("socket_disconnected", nspName(s.nsp), s.Id(), "disconnect")
+}
+
+func (a *admin) roomJoined(s *socket, room string) {
+	if s.nsp == a.nsp || room == allRoom {
+		return
+	}
+	a.nsp.operator(nil).Emit("room_joined", nspName(s.nsp), room, s.Id())
+}
+
+func (a *admin) roomLeft(s *socket, room string) {
+	if s.nsp == a.nsp || room == allRoom {
+		return
+	}
+	a.nsp.operator(nil).Emit("room_left", nspName(s.nsp), room, s.Id())
+}
+
+func (a *admin) packetReceived(bytes int) {
+	a.record("packetsIn", "", 1)
+	a.record("bytesIn", "", bytes)
+}
+
+func (a *admin) packetSent(bytes int) {
+	a.record("packetsOut", "", 1)
+	a.record("bytesOut", "", bytes)
+}
+
+// nspName returns the name of the namespace n as the dashboard shows it, "/" for the root.
+func nspName(n *namespace) string {
+	if n.Name() == "" {
+		return "/"
+	}
+	return n.Name()
+}
+
+// transport returns the name of the transport of c, or "" if the engine.io connection doesn't tell.
+func transport(c *conn) string {
+	if t, ok := c.Conn.(interface{ Transport() string }); ok {
+		return t.Transport()
+	}
+	return ""
+}
+
+// serializeSocket returns s as the dashboard lists it.
+func serializeSocket(s *socket) map[string]interface{} {
+	handshake := map[string]interface{}{
+		"issued": s.issued.UnixNano() / int64(time.Millisecond),
+		"time":   s.issued.UTC().Format(time.RFC1123),
+		"auth":   s.Auth(),
+	}
+	if r := s.Request(); r != nil {
+		headers := make(map[string]string)
+		for k, v := range r.Header {
+			headers[strings.ToLower(k)] = strings.Join(v, ", ")
+		}
+		query := make(map[string]string)
+		for k, v := range r.URL.Query() {
+			query[k] = v[0]
+		}
+		handshake["address"] = r.RemoteAddr
+		handshake["headers"] = headers
+		handshake["query"] = query
+		handshake["secure"] = r.TLS != nil
+		handshake["url"] = r.URL.String()
+	}
+	return map[string]interface{}{
+		"id":        s.Id(),
+		"clientId":  s.conn.Id(),
+		"transport": transport(s.conn),
+		"nsp":       nspName(s.nsp),
+		"data":      s.Data(),
+		"handshake": handshake,
+		"rooms":     s.Rooms(),
+	}
+}
@@ -0,0 +1,109 @@
+package socketio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdmin(t *testing.T) {
+	Convey("Inspect server from admin namespace", t, func() {
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		So(server.Instrument(AdminOptions{}), ShouldNotBeNil)
+		So(server.Instrument(AdminOptions{
+			Username:      "admin",
+			Password:      "secret",
+			StatsInterval: 50 * time.Millisecond,
+		}), ShouldBeNil)
+		ids := make(chan string, 1)
+		server.On("connection", func(so Socket) {
+			so.Join("a")
+			ids <- so.Id()
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		id := <-ids
+
+		poll := func(method, query, body string) string {
+			req, err := http.NewRequest(method, h.URL+"/socket.io/?EIO=4&transport=polling"+query, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			b, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return string(b)
+		}
+		// connect opens a session connecting to the admin namespace with auth, and returns the
+		// func reading the next packet which starts with prefix.
+		connect := func(auth string) (string, func(prefix string) string) {
+			var info struct {
+				Sid string `json:"sid"`
+			}
+			open := poll("GET", "", "")
+			So(json.Unmarshal([]byte(open[1:]), &info), ShouldBeNil)
+			So(poll("POST", "&sid="+info.Sid, "40/admin,"+auth), ShouldEqual, "ok")
+			var records []string
+			return info.Sid, func(prefix string) string {
+				for {
+					for len(records) > 0 {
+						r := records[0]
+						records = records[1:]
+						if strings.HasPrefix(r, prefix) {
+							return r
+						}
+					}
+					records = strings.Split(poll("GET", "&sid="+info.Sid, ""), "\x1e")
+				}
+			}
+		}
+
+		Convey("with wrong credentials", func() {
+			_, next := connect(`{"username":"admin","password":"wrong"}`)
+			So(next("44/admin,"), ShouldEqual, `44/admin,{"message":"invalid credentials"}`)
+		})
+
+		Convey("with credentials", func() {
+			sid, next := connect(`{"username":"admin","password":"secret"}`)
+			So(next("4"), ShouldStartWith, `40/admin,{"sid":`)
+			So(next(`42/admin,["config"`), ShouldContainSubstring, `"JOIN"`)
+
+			var sockets []struct {
+				Id    string   `json:"id"`
+				Nsp   string   `json:"nsp"`
+				Rooms []string `json:"rooms"`
+			}
+			var event string
+			all := next(`42/admin,["all_sockets"`)
+			So(json.Unmarshal([]byte(all[len("42/admin,"):]), &[]interface{}{&event, &sockets}), ShouldBeNil)
+			So(len(sockets), ShouldEqual, 1)
+			So(sockets[0].Id, ShouldEqual, id)
+			So(sockets[0].Nsp, ShouldEqual, "/")
+			So(sockets[0].Rooms, ShouldResemble, []string{"a"})
+
+			stats := next(`42/admin,["server_stats"`)
+			So(stats, ShouldContainSubstring, `"clientsCount":2`)
+			So(stats, ShouldContainSubstring, `{"name":"/","socketsCount":1}`)
+
+			So(poll("POST", "&sid="+sid, `42/admin,["join","/","b","a"]`), ShouldEqual, "ok")
+			So(next(`42/admin,["room_joined"`), ShouldEqual, `42/admin,["room_joined","/","b","`+id+`"]`)
+
+			So(poll("POST", "&sid="+sid, `42/admin,["_disconnect","/",false,"`+id+`"]`), ShouldEqual, "ok")
+			So(next(`42/admin,["socket_disconnected"`), ShouldEqual, `42/admin,["socket_disconnected","/","`+id+`","disconnect"]`)
+			So(server.Socket(id), ShouldBeNil)
+		})
+	})
+}
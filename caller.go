@@ -1,7 +1,6 @@
 package socketio
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 )
@@ -10,14 +9,62 @@ type caller struct {
 	Func       reflect.Value
 	Args       []reflect.Type
 	NeedSocket bool
+	NeedError  bool
 }
 
+var (
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	socketType = reflect.TypeOf((*Socket)(nil)).Elem()
+)
+
+// HandlerError is returned when a func is registered which can't handle events.
+type HandlerError struct {
+	Func   reflect.Type // Func is the type of the func, nil if it isn't one.
+	Reason string
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("invalid handler %v: %s", e.Func, e.Reason)
+}
+
+// ArgsError is returned when the args of an event can't be passed to its handler.
+type ArgsError struct {
+	Event string
+	Err   error
+}
+
+func (e *ArgsError) Error() string {
+	return fmt.Sprintf("args of %q: %s", e.Event, e.Err)
+}
+
+func (e *ArgsError) Unwrap() error {
+	return e.Err
+}
+
+// newCaller returns the caller of the event handler f.
 func newCaller(f interface{}) (*caller, error) {
+	return makeCaller(f, false)
+}
+
+// newAckCaller returns the caller of the ack func f, which may take an error first, see TakeError.
+func newAckCaller(f interface{}) (*caller, error) {
+	c, err := makeCaller(f, true)
+	if err != nil {
+		return nil, err
+	}
+	c.TakeError()
+	return c, nil
+}
+
+func makeCaller(f interface{}, ack bool) (*caller, error) {
 	fv := reflect.ValueOf(f)
 	if fv.Kind() != reflect.Func {
-		return nil, fmt.Errorf("f is not func")
+		return nil, &HandlerError{Func: reflect.TypeOf(f), Reason: "not a func"}
 	}
 	ft := fv.Type()
+	if err := checkFunc(ft, ack); err != nil {
+		return nil, err
+	}
 	if ft.NumIn() == 0 {
 		return &caller{
 			Func: fv,
@@ -28,7 +75,7 @@ func newCaller(f interface{}) (*caller, error) {
 		args[i] = ft.In(i)
 	}
 	needSocket := false
-	if args[0].Name() == "Socket" {
+	if args[0] == socketType {
 		args = args[1:]
 		needSocket = true
 	}
@@ -39,6 +86,55 @@ func newCaller(f interface{}) (*caller, error) {
 	}, nil
 }
 
+// checkFunc checks that the args of ft can be decoded from JSON and what it returns encoded, with
+// an optional error last. The error arg of an ack func is only allowed first, after the Socket.
+func checkFunc(ft reflect.Type, ack bool) error {
+	if ft.IsVariadic() {
+		return &HandlerError{Func: ft, Reason: "variadic funcs are not supported"}
+	}
+	first := 0
+	for i, n := 0, ft.NumIn(); i < n; i++ {
+		t := ft.In(i)
+		if i == 0 && t == socketType {
+			first = 1
+			continue
+		}
+		if t == errorType {
+			if ack && i == first {
+				continue
+			}
+			return &HandlerError{Func: ft, Reason: fmt.Sprintf("arg %d of type error is only allowed first in ack funcs", i)}
+		}
+		if !isJSONType(t) {
+			return &HandlerError{Func: ft, Reason: fmt.Sprintf("arg %d of type %v can't be decoded", i, t)}
+		}
+	}
+	for i, n := 0, ft.NumOut(); i < n; i++ {
+		t := ft.Out(i)
+		if t == errorType && i == n-1 {
+			continue
+		}
+		if t == errorType || !isJSONType(t) {
+			return &HandlerError{Func: ft, Reason: fmt.Sprintf("result %d of type %v can't be encoded", i, t)}
+		}
+	}
+	return nil
+}
+
+// isJSONType returns false for the kinds of t which never go through JSON.
+func isJSONType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return false
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	}
+	return true
+}
+
 func (c *caller) GetArgs() []interface{} {
 	ret := make([]interface{}, len(c.Args))
 	for i, argT := range c.Args {
@@ -51,33 +147,63 @@ func (c *caller) GetArgs() []interface{} {
 	return ret
 }
 
-func (c *caller) Call(so Socket, args []interface{}) []reflect.Value {
-	var a []reflect.Value
-	diff := 0
+// TakeError makes the caller pass an error before the args if the first arg of the func (after
+// the Socket) is an error. It returns true if so.
+func (c *caller) TakeError() bool {
+	if len(c.Args) > 0 && c.Args[0] == errorType {
+		c.Args = c.Args[1:]
+		c.NeedError = true
+	}
+	return c.NeedError
+}
+
+// Call calls the func with args, and returns its results apart from the error, which is the last
+// result if the func declares one.
+func (c *caller) Call(so Socket, args []interface{}) ([]interface{}, error) {
+	return c.CallError(so, nil, args)
+}
+
+// CallError calls the func with err as the error arg, which is only passed if NeedError.
+func (c *caller) CallError(so Socket, err error, args []interface{}) ([]interface{}, error) {
+	a := make([]reflect.Value, 0, len(args)+2)
 	if c.NeedSocket {
-		diff = 1
-		a = make([]reflect.Value, len(args)+1)
-		a[0] = reflect.ValueOf(so)
-	} else {
-		a = make([]reflect.Value, len(args))
+		a = append(a, reflect.ValueOf(so))
+	}
+	if c.NeedError {
+		ev := reflect.Zero(errorType)
+		if err != nil {
+			ev = reflect.ValueOf(&err).Elem()
+		}
+		a = append(a, ev)
 	}
 
 	// Issue 95 from original.
 	if len(args) != len(c.Args) {
-		return []reflect.Value{reflect.ValueOf([]interface{}{}), reflect.ValueOf(errors.New("Arguments do not match"))}
+		return nil, &ArgsError{Err: fmt.Errorf("got %d args, the handler takes %d", len(args), len(c.Args))}
 	}
 
 	for i, arg := range args {
 		v := reflect.ValueOf(arg)
-		if c.Args[i].Kind() != reflect.Ptr {
-			if v.IsValid() {
-				v = v.Elem()
-			} else {
-				v = reflect.Zero(c.Args[i])
-			}
+		if !v.IsValid() {
+			v = reflect.Zero(c.Args[i])
+		} else if c.Args[i].Kind() != reflect.Ptr {
+			v = v.Elem()
 		}
-		a[i+diff] = v
+		a = append(a, v)
 	}
 
-	return c.Func.Call(a)
+	retV := c.Func.Call(a)
+	var failed error
+	if n := c.Func.Type().NumOut(); n > 0 && c.Func.Type().Out(n-1) == errorType {
+		failed, _ = retV[n-1].Interface().(error)
+		retV = retV[:n-1]
+	}
+	if len(retV) == 0 {
+		return nil, failed
+	}
+	ret := make([]interface{}, len(retV))
+	for i, v := range retV {
+		ret[i] = v.Interface()
+	}
+	return ret, failed
 }
@@ -0,0 +1,48 @@
+package socketio
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCaller(t *testing.T) {
+	Convey("Check handler signatures", t, func() {
+		c, err := newCaller(func(so Socket, msg string) (int, error) { return 0, nil })
+		So(err, ShouldBeNil)
+		So(c.NeedSocket, ShouldBeTrue)
+		So(len(c.Args), ShouldEqual, 1)
+
+		for _, f := range []interface{}{
+			"f",
+			func(msg ...string) {},
+			func(c chan int) {},
+			func(so Socket, other Socket) {},
+			func() func() { return nil },
+			func() (error, int) { return nil, 0 },
+			func(a int, err error) {},
+			func(err error, msg string) {},
+		} {
+			_, err := newCaller(f)
+			So(err, ShouldHaveSameTypeAs, &HandlerError{})
+		}
+	})
+
+	Convey("Take an error first in ack funcs only", t, func() {
+		c, err := newAckCaller(func(err error, msg string) {})
+		So(err, ShouldBeNil)
+		So(c.NeedError, ShouldBeTrue)
+		So(len(c.Args), ShouldEqual, 1)
+
+		_, err = newAckCaller(func(msg string, err error) {})
+		So(err, ShouldHaveSameTypeAs, &HandlerError{})
+	})
+
+	Convey("Take the socket by type only", t, func() {
+		type Socket struct{ Name string }
+		c, err := newCaller(func(so Socket) {})
+		So(err, ShouldBeNil)
+		So(c.NeedSocket, ShouldBeFalse)
+		So(len(c.Args), ShouldEqual, 1)
+	})
+}
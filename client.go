@@ -0,0 +1,487 @@
+package socketio
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pschlump/socketio/engineio"
+)
+
+// ClientOptions are the options of Dial. The zero value is usable.
+type ClientOptions struct {
+	Transports        []string      // Transports of engine.io. If nil, client will use ["polling", "websocket"] and upgrade to websocket.
+	Path              string        // Path of socket.io on the server. Default is "/socket.io/".
+	Header            http.Header   // Header sent with every request, e.g. for cookies or authorization.
+	NoReconnect       bool          // NoReconnect disables reconnecting after the connection is lost.
+	ReconnectAttempts int           // ReconnectAttempts is the number of tries before giving up. Default 0 means try forever.
+	ReconnectDelay    time.Duration // ReconnectDelay is the wait before the first try, doubled after each failure. Default is 1s.
+	ReconnectDelayMax time.Duration // ReconnectDelayMax caps ReconnectDelay. Default is 5s.
+
+	// Protocol is the socket.io protocol of the client: Protocol over engine.io v3, the default, or
+	// ProtocolV5 over engine.io v4, which only joins the namespace of the dial url.
+	Protocol int
+}
+
+// Client is the client of socket.io. It is also the socket of the namespace given in the dial url.
+type Client struct {
+	*ClientSocket
+	url       url.URL
+	opts      ClientOptions
+	conn      engineio.Conn
+	connLock  sync.RWMutex
+	writeLock sync.Mutex
+	sockets   map[string]*ClientSocket
+	closed    bool
+	closeChan chan struct{}
+	lock      sync.RWMutex
+}
+
+// ClientSocket is the client side of one namespace.
+//
+// Handlers registered with On and ack functions passed to Emit get the event arguments only,
+// they can't take a Socket as first argument. The events "connect", "disconnect", "reconnect"
+// and "error" are raised by the client itself. The first arg of "error" gets why the server refused
+// the namespace: the message, or the data of the ConnectError, for socket.io v4, and an object with
+// the message and data for v5.
+type ClientSocket struct {
+	client    *Client
+	namespace string
+	handlers  *baseHandler
+	acks      map[int]*caller
+	id        int
+	connected bool
+	lock      sync.Mutex
+}
+
+var errClientHandlerSocket = errors.New("client handler can't take a Socket argument")
+
+// Dial connects to the socket.io server at rawurl. The path of rawurl is the namespace to join,
+// e.g. "http://localhost:9000/chat", the path of socket.io on the server is taken from opts.
+// If opts is nil, default options are used.
+func Dial(rawurl string, opts *ClientOptions) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Client{
+		sockets:   make(map[string]*ClientSocket),
+		closeChan: make(chan struct{}),
+	}
+	if opts != nil {
+		ret.opts = *opts
+	}
+	if ret.opts.Path == "" {
+		ret.opts.Path = "/socket.io/"
+	}
+	if ret.opts.ReconnectDelay <= 0 {
+		ret.opts.ReconnectDelay = time.Second
+	}
+	if ret.opts.ReconnectDelayMax <= 0 {
+		ret.opts.ReconnectDelayMax = 5 * time.Second
+	}
+	if ret.opts.Protocol == 0 {
+		ret.opts.Protocol = Protocol
+	}
+	name := u.Path
+	ret.url = *u
+	ret.url.Path = ret.opts.Path
+	if ret.opts.Protocol == ProtocolV5 {
+		query := ret.url.Query()
+		query.Set("EIO", "4")
+		ret.url.RawQuery = query.Encode()
+	}
+	ret.ClientSocket = ret.newSocket(name)
+
+	conn, err := ret.connect()
+	if err != nil {
+		return nil, err
+	}
+	go ret.run(conn)
+	return ret, nil
+}
+
+// Id returns the session id of the underlying engine.io connection.
+func (c *Client) Id() string {
+	return c.getConn().Id()
+}
+
+// Of returns the socket of namespace name, joining it if not joined yet.
+func (c *Client) Of(name string) *ClientSocket {
+	if name == "/" {
+		name = ""
+	}
+	c.lock.RLock()
+	ret, ok := c.sockets[name]
+	c.lock.RUnlock()
+	if ok {
+		return ret
+	}
+	ret = c.newSocket(name)
+	ret.sendConnect()
+	return ret
+}
+
+// Close disconnects all namespaces and closes the connection. The client won't reconnect.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.closeChan)
+	sockets := make([]*ClientSocket, 0, len(c.sockets))
+	for _, s := range c.sockets {
+		sockets = append(sockets, s)
+	}
+	c.lock.Unlock()
+
+	for _, s := range sockets {
+		s.send(packet{
+			Type: _DISCONNECT,
+			Id:   -1,
+			NSP:  s.namespace,
+		})
+	}
+	return c.getConn().Close()
+}
+
+func (c *Client) newSocket(name string) *ClientSocket {
+	if name == "/" {
+		name = ""
+	}
+	ret := &ClientSocket{
+		client:    c,
+		namespace: name,
+		handlers:  newBaseHandler(name, nil),
+		acks:      make(map[int]*caller),
+	}
+	c.lock.Lock()
+	if s, ok := c.sockets[name]; ok {
+		ret = s
+	} else {
+		c.sockets[name] = ret
+	}
+	c.lock.Unlock()
+	return ret
+}
+
+func (c *Client) socket(name string) *ClientSocket {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.sockets[name]
+}
+
+func (c *Client) isClosed() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.closed
+}
+
+func (c *Client) getConn() engineio.Conn {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+	return c.conn
+}
+
+// connect opens a new engine.io connection and joins every namespace. With Protocol the server joins
+// the root by itself.
+func (c *Client) connect() (engineio.Conn, error) {
+	conn, err := engineio.Dial(c.url.String(), c.opts.Transports, c.opts.Header)
+	if err != nil {
+		return nil, err
+	}
+	c.connLock.Lock()
+	c.conn = conn
+	c.connLock.Unlock()
+
+	c.lock.RLock()
+	sockets := make([]*ClientSocket, 0, len(c.sockets))
+	for _, s := range c.sockets {
+		sockets = append(sockets, s)
+	}
+	c.lock.RUnlock()
+	for _, s := range sockets {
+		s.sendConnect()
+	}
+	return conn, nil
+}
+
+func (c *Client) run(conn engineio.Conn) {
+	for {
+		c.loop(conn)
+		conn.Close()
+
+		c.lock.RLock()
+		sockets := make([]*ClientSocket, 0, len(c.sockets))
+		for _, s := range c.sockets {
+			sockets = append(sockets, s)
+		}
+		c.lock.RUnlock()
+		for _, s := range sockets {
+			s.onDisconnect()
+		}
+
+		if c.isClosed() || c.opts.NoReconnect {
+			return
+		}
+		if conn = c.reconnect(); conn == nil {
+			return
+		}
+		c.ClientSocket.fire("reconnect")
+	}
+}
+
+func (c *Client) reconnect() engineio.Conn {
+	delay := c.opts.ReconnectDelay
+	for i := 0; c.opts.ReconnectAttempts == 0 || i < c.opts.ReconnectAttempts; i++ {
+		select {
+		case <-c.closeChan:
+			return nil
+		case <-time.After(delay):
+		}
+		if conn, err := c.connect(); err == nil {
+			return conn
+		}
+		delay *= 2
+		if delay > c.opts.ReconnectDelayMax {
+			delay = c.opts.ReconnectDelayMax
+		}
+	}
+	return nil
+}
+
+func (c *Client) loop(conn engineio.Conn) error {
+	for {
+		decoder := newDecoder(conn)
+		var p packet
+		if err := decoder.Decode(&p); err != nil {
+			return err
+		}
+		s := c.socket(p.NSP)
+		if s == nil {
+			decoder.Close()
+			continue
+		}
+		switch p.Type {
+		case _CONNECT:
+			s.onConnect()
+		case _DISCONNECT:
+			s.onDisconnect()
+		case _ERROR:
+			s.onError(decoder, &p)
+		case _EVENT, _BINARY_EVENT:
+			ret, err := s.onEvent(decoder, &p)
+			if err == nil && p.Id >= 0 {
+				s.send(packet{
+					Type: _ACK,
+					Id:   p.Id,
+					NSP:  s.namespace,
+					Data: ret,
+				})
+			}
+		case _ACK, _BINARY_ACK:
+			s.onAck(p.Id, decoder, &p)
+		}
+		decoder.Close()
+	}
+}
+
+func (c *Client) send(p packet) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	encoder := newEncoder(c.getConn())
+	return encoder.Encode(p)
+}
+
+// Name returns the name of namespace.
+func (s *ClientSocket) Name() string {
+	return s.namespace
+}
+
+// Connected returns true if the server accepted the namespace and it is not disconnected.
+func (s *ClientSocket) Connected() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.connected
+}
+
+// On registers the function f to handle message.
+func (s *ClientSocket) On(message string, f interface{}) error {
+	c, err := newCaller(f)
+	if err != nil {
+		return err
+	}
+	if c.NeedSocket {
+		return errClientHandlerSocket
+	}
+	s.handlers.lock.Lock()
+	s.handlers.events[message] = c
+	s.handlers.lock.Unlock()
+	return nil
+}
+
+// Emit emits the message with given args. If the last arg is a func, it will be called with the ack of server.
+func (s *ClientSocket) Emit(message string, args ...interface{}) error {
+	var c *caller
+	if l := len(args); l > 0 {
+		fv := reflect.ValueOf(args[l-1])
+		if fv.Kind() == reflect.Func {
+			var err error
+			c, err = newAckCaller(args[l-1])
+			if err != nil {
+				return err
+			}
+			if c.NeedSocket {
+				return errClientHandlerSocket
+			}
+			args = args[:l-1]
+		}
+	}
+	p := packet{
+		Type: _EVENT,
+		Id:   -1,
+		NSP:  s.namespace,
+		Data: append([]interface{}{message}, args...),
+	}
+	if c != nil {
+		s.lock.Lock()
+		p.Id = s.id
+		s.id++
+		if s.id < 0 {
+			s.id = 0
+		}
+		s.acks[p.Id] = c
+		s.lock.Unlock()
+	}
+	if err := s.send(p); err != nil {
+		if c != nil {
+			s.lock.Lock()
+			delete(s.acks, p.Id)
+			s.lock.Unlock()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ClientSocket) send(p packet) error {
+	return s.client.send(p)
+}
+
+func (s *ClientSocket) sendConnect() error {
+	if s.namespace == "" && s.client.opts.Protocol != ProtocolV5 {
+		return nil
+	}
+	return s.send(packet{
+		Type: _CONNECT,
+		Id:   -1,
+		NSP:  s.namespace,
+	})
+}
+
+func (s *ClientSocket) onConnect() {
+	s.lock.Lock()
+	s.connected = true
+	s.lock.Unlock()
+	s.fire("connect")
+}
+
+// onDisconnect fails the pending acks with DisconnectedError, and raises "disconnect" if connected.
+func (s *ClientSocket) onDisconnect() {
+	s.lock.Lock()
+	connected := s.connected
+	s.connected = false
+	acks := s.acks
+	s.acks = make(map[int]*caller)
+	s.lock.Unlock()
+	for _, c := range acks {
+		if c.NeedError {
+			c.CallError(nil, DisconnectedError, make([]interface{}, len(c.Args)))
+		}
+	}
+	if connected {
+		s.fire("disconnect")
+	}
+}
+
+// fire calls the handler of message, which is raised by client, with zero value args.
+func (s *ClientSocket) fire(message string) {
+	s.handlers.lock.RLock()
+	c, ok := s.handlers.events[message]
+	s.handlers.lock.RUnlock()
+	if !ok {
+		return
+	}
+	c.Call(nil, c.GetArgs())
+}
+
+// onError passes the data of the ERROR packet p, which tells why the server refused the namespace, to
+// the first arg of the "error" handler.
+func (s *ClientSocket) onError(decoder *decoder, p *packet) error {
+	s.handlers.lock.RLock()
+	c, ok := s.handlers.events["error"]
+	s.handlers.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	args := c.GetArgs()
+	if len(args) > 0 {
+		p.Data = args[0]
+		if err := decoder.DecodeData(p); err != nil {
+			return err
+		}
+	}
+	c.Call(nil, args)
+	return nil
+}
+
+func (s *ClientSocket) onEvent(decoder *decoder, p *packet) ([]interface{}, error) {
+	s.handlers.lock.RLock()
+	c, ok := s.handlers.events[decoder.Message()]
+	s.handlers.lock.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	args, err := decodeArgs(c, decoder, p)
+	if err != nil {
+		return nil, err
+	}
+	return c.Call(nil, args)
+}
+
+func (s *ClientSocket) onAck(id int, decoder *decoder, p *packet) error {
+	s.lock.Lock()
+	c, ok := s.acks[id]
+	delete(s.acks, id)
+	s.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	args, err := decodeArgs(c, decoder, p)
+	if err != nil {
+		return err
+	}
+	c.Call(nil, args)
+	return nil
+}
+
+// decodeArgs decodes the data of packet p to the args of c, padding missing args with nil.
+func decodeArgs(c *caller, decoder *decoder, p *packet) ([]interface{}, error) {
+	args := c.GetArgs()
+	olen := len(args)
+	if olen > 0 {
+		p.Data = &args
+		if err := decoder.DecodeData(p); err != nil {
+			return nil, err
+		}
+	}
+	for i := len(args); i < olen; i++ {
+		args = append(args, nil)
+	}
+	return args, nil
+}
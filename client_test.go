@@ -0,0 +1,171 @@
+package socketio
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+	Convey("Dial server", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		serverAck := make(chan string, 1)
+		server.On("connection", func(so Socket) {
+			so.On("echo", func(msg string) string {
+				return msg
+			})
+			so.On("ask", func(msg string) {
+				so.Emit("answer", msg+"!")
+			})
+			so.On("question", func(msg string) {
+				so.Emit("question", msg, func(answer string) {
+					serverAck <- answer
+				})
+			})
+			so.On("kick", func() {
+				so.Emit("disconnect")
+			})
+			so.On("slow", func() {
+				time.Sleep(100 * time.Millisecond)
+			})
+		})
+		server.Of("/chat").On("connection", func(so Socket) {
+			so.On("echo", func(msg string) string {
+				return msg
+			})
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{ReconnectDelay: 10 * time.Millisecond})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		So(client.Id(), ShouldNotEqual, "")
+		So(client.Name(), ShouldEqual, "")
+
+		Convey("handler with socket", func() {
+			So(client.On("bad", func(so Socket) {}), ShouldEqual, errClientHandlerSocket)
+		})
+
+		Convey("emit with ack", func() {
+			ack := make(chan string, 1)
+			err := client.Emit("echo", "hello", func(msg string) {
+				ack <- msg
+			})
+			So(err, ShouldBeNil)
+			So(<-ack, ShouldEqual, "hello")
+		})
+
+		Convey("fail acks on disconnect", func() {
+			ack := make(chan error, 1)
+			So(client.Emit("slow", func(err error) {
+				ack <- err
+			}), ShouldBeNil)
+			So(client.Close(), ShouldBeNil)
+			So(<-ack, ShouldEqual, DisconnectedError)
+		})
+
+		Convey("receive event", func() {
+			answer := make(chan string, 1)
+			client.On("answer", func(msg string) {
+				answer <- msg
+			})
+			So(client.Emit("ask", "why"), ShouldBeNil)
+			So(<-answer, ShouldEqual, "why!")
+			So(client.Connected(), ShouldBeTrue)
+		})
+
+		Convey("ack to server", func() {
+			client.On("question", func(msg string) string {
+				return msg + "?"
+			})
+			So(client.Emit("question", "who"), ShouldBeNil)
+			So(<-serverAck, ShouldEqual, "who?")
+		})
+
+		Convey("join namespace", func() {
+			chat := client.Of("/chat")
+			So(client.Of("/chat"), ShouldEqual, chat)
+			So(chat.Name(), ShouldEqual, "/chat")
+			ack := make(chan string, 1)
+			err := chat.Emit("echo", "hi chat", func(msg string) {
+				ack <- msg
+			})
+			So(err, ShouldBeNil)
+			So(<-ack, ShouldEqual, "hi chat")
+			So(chat.Connected(), ShouldBeTrue)
+		})
+
+		Convey("refused namespace", func() {
+			refused := make(chan string, 1)
+			nope := client.Of("/nope")
+			nope.On("error", func(msg string) {
+				refused <- msg
+			})
+			So(<-refused, ShouldEqual, "Invalid namespace")
+			So(nope.Connected(), ShouldBeFalse)
+		})
+
+		Convey("reconnect", func() {
+			reconnected := make(chan bool, 1)
+			client.On("reconnect", func() {
+				reconnected <- true
+			})
+			id := client.Id()
+			So(client.Emit("kick"), ShouldBeNil)
+			select {
+			case <-reconnected:
+			case <-time.After(5 * time.Second):
+				So("reconnect timeout", ShouldEqual, "")
+			}
+			So(client.Id(), ShouldNotEqual, id)
+		})
+	})
+}
+
+func TestClientV5(t *testing.T) {
+	Convey("Dial with socket.io v5", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		root := make(chan string, 2)
+		server.On("connection", func(so Socket) {
+			root <- so.Id()
+		})
+		chat := make(chan Socket, 1)
+		server.Of("/chat").On("connection", func(so Socket) {
+			so.On("echo", func(msg string, b []byte) (string, []byte) {
+				return msg, b
+			})
+			chat <- so
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		for _, transports := range [][]string{{"polling"}, nil} {
+			client, err := Dial(h.URL+"/chat", &ClientOptions{NoReconnect: true, Protocol: ProtocolV5, Transports: transports})
+			So(err, ShouldBeNil)
+			so := <-chat
+			So(so.Request().URL.Query().Get("EIO"), ShouldEqual, "4")
+			ack := make(chan string, 1)
+			So(client.Emit("echo", "hi", []byte{1, 2}, func(msg string, b []byte) {
+				ack <- msg + string(b)
+			}), ShouldBeNil)
+			So(<-ack, ShouldEqual, "hi\x01\x02")
+			So(client.Connected(), ShouldBeTrue)
+			client.Close()
+		}
+		So(len(root), ShouldEqual, 0)
+
+		client, err := Dial(h.URL+"/nope", &ClientOptions{NoReconnect: true, Protocol: ProtocolV5})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		refused := make(chan map[string]interface{}, 1)
+		client.On("error", func(e map[string]interface{}) {
+			refused <- e
+		})
+		So(<-refused, ShouldResemble, map[string]interface{}{"message": "Invalid namespace"})
+	})
+}
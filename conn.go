@@ -0,0 +1,344 @@
+package socketio
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pschlump/socketio/engineio"
+)
+
+// bufferLimiter is the engine.io connection which limits the bytes a client sends at once.
+type bufferLimiter interface {
+	MaxHTTPBufferSize() int64
+}
+
+// volatileConn is the engine.io connection which can drop messages which don't reach the client at once.
+type volatileConn interface {
+	NextVolatileWriter(engineio.MessageType) (io.WriteCloser, error)
+}
+
+// conn is the socket.io connection over one engine.io connection. It holds a socket for each
+// namespace the client joined, and dispatches packets to them by namespace.
+type conn struct {
+	engineio.Conn
+	root      *namespace
+	protocol  int
+	sockets   map[string]*socket
+	closing   bool
+	lock      sync.RWMutex
+	writeLock sync.Mutex
+}
+
+func newConn(c engineio.Conn, root *namespace) *conn {
+	ret := &conn{
+		Conn:     c,
+		root:     root,
+		protocol: Protocol,
+		sockets:  make(map[string]*socket),
+	}
+	if r := c.Request(); r != nil && r.URL.Query().Get("EIO") == "4" {
+		ret.protocol = ProtocolV5
+	}
+	return ret
+}
+
+// encode writes the packet p. Packets are written one by one, so attachments of different
+// namespaces don't interleave.
+func (c *conn) encode(p packet) error {
+	return c.encodeTo(c.Conn, p)
+}
+
+// encodeTo sends p through fw, holding the write lock so the frames of packets don't mix.
+func (c *conn) encodeTo(fw frameWriter, p packet) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	w := &countWriter{w: fw}
+	encoder := newEncoder(w)
+	if err := encoder.Encode(p); err != nil {
+		return err
+	}
+	// attachments are written as frames after the packet
+	if w.frames > 1 {
+		p.Type += _BINARY_EVENT - _EVENT
+	}
+	c.metrics().PacketSent(p.Type.String(), w.n)
+	c.root.watch.get().packetSent(w.n)
+	return nil
+}
+
+// encodeVolatile sends p if it reaches the client at once, or returns DroppedError, see Socket.Volatile.
+// Connections which can't tell send p as encode does.
+func (c *conn) encodeVolatile(p packet) error {
+	v, ok := c.Conn.(volatileConn)
+	if !ok {
+		return c.encode(p)
+	}
+	err := c.encodeTo(volatileWriter{conn: v, attachments: c.Conn}, p)
+	if err == engineio.NotWritableError {
+		return DroppedError
+	}
+	return err
+}
+
+// volatileWriter writes the packet with NextVolatileWriter. Its attachments follow it once it is sent.
+type volatileWriter struct {
+	conn        volatileConn
+	attachments frameWriter
+}
+
+func (w volatileWriter) NextWriter(t engineio.MessageType) (io.WriteCloser, error) {
+	if t == engineio.MessageText {
+		return w.conn.NextVolatileWriter(t)
+	}
+	return w.attachments.NextWriter(t)
+}
+
+// socket returns the socket of namespace nsp, where "/" is the root namespace too.
+func (c *conn) socket(nsp string) *socket {
+	if nsp == "/" {
+		nsp = ""
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.sockets[nsp]
+}
+
+func (c *conn) log() Logger {
+	return c.root.logger.get()
+}
+
+func (c *conn) metrics() Metrics {
+	return c.root.meter.get()
+}
+
+func (c *conn) loop() (err error) {
+	defer func() {
+		c.log().Debug("connection closed", "sid", c.Id(), "err", err)
+		c.Close()
+		c.lock.Lock()
+		sockets := c.sockets
+		c.sockets = make(map[string]*socket)
+		closing := c.closing
+		c.lock.Unlock()
+		for _, s := range sockets {
+			if closing || !s.nsp.recovery.keep(s) {
+				s.onDisconnect()
+			} else {
+				c.log().Debug("socket kept for recovery", "sid", s.Id(), "nsp", s.namespace)
+			}
+		}
+	}()
+
+	// socket.io v5 clients send CONNECT for the root namespace too, so it isn't connected here.
+	if c.protocol != ProtocolV5 {
+		p := packet{
+			Type: _CONNECT,
+			Id:   -1,
+		}
+		if err := c.onConnect(nil, &p); err != nil {
+			return err
+		}
+	}
+	var maxBinary int64
+	if l, ok := c.Conn.(bufferLimiter); ok {
+		maxBinary = l.MaxHTTPBufferSize()
+	}
+	r := &countReader{r: c.Conn}
+	for {
+		r.n = 0
+		decoder := newDecoder(r)
+		decoder.maxBinary = maxBinary
+		var p packet
+		if err := decoder.Decode(&p); err != nil {
+			return err
+		}
+		// binary packets are decoded as the plain type
+		typ := p.Type.String()
+		done, err := c.onPacket(decoder, &p)
+		c.metrics().PacketReceived(typ, r.n)
+		c.root.watch.get().packetReceived(r.n)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// onPacket passes the packet p to the socket of its namespace. It returns true when the connection
+// has no socket left.
+func (c *conn) onPacket(decoder *decoder, p *packet) (bool, error) {
+	s := c.socket(p.NSP)
+	switch p.Type {
+	case _CONNECT:
+		if s != nil {
+			decoder.Close()
+			return false, nil
+		}
+		return false, c.onConnect(decoder, p)
+	case _DISCONNECT:
+		decoder.Close()
+		if s == nil {
+			return false, nil
+		}
+		if c.remove(s) {
+			s.onDisconnect()
+		}
+		if c.empty() {
+			c.Close()
+			return true, nil
+		}
+		return false, nil
+	default:
+		if s == nil {
+			decoder.Close()
+			return false, nil
+		}
+		return false, s.onPacket(decoder, p)
+	}
+}
+
+// onConnect joins the namespace of the CONNECT packet p, or sends an ERROR if there is no such
+// namespace or a middleware rejects it. decoder is nil for the root namespace of socket.io v4
+// clients, which is joined without CONNECT.
+func (c *conn) onConnect(decoder *decoder, p *packet) error {
+	nsp := c.root.get(p.NSP)
+	if nsp == nil {
+		decoder.Close()
+		c.log().Warn("invalid namespace", "sid", c.Id(), "nsp", p.NSP)
+		return c.sendError(p.NSP, &ConnectError{Message: "Invalid namespace"})
+	}
+	s := newSocket(c, nsp)
+	if decoder != nil {
+		var auth map[string]interface{}
+		p.Data = &auth
+		if err := decoder.DecodeData(p); err != nil {
+			return err
+		}
+		p.Data = nil
+		s.auth = auth
+	}
+
+	// A recovered socket skips the middlewares, which ran when it connected first.
+	var old *socket
+	var rooms []string
+	recovered := false
+	if pid, _ := s.auth["pid"].(string); pid != "" {
+		if old = nsp.recovery.take(pid, nsp.Name()); old != nil {
+			offset, _ := s.auth["offset"].(string)
+			rooms, recovered = s.restore(old, offset)
+		}
+	}
+	if !recovered {
+		if err := nsp.runMiddlewares(s); err != nil {
+			c.log().Info("connection rejected", "sid", c.Id(), "nsp", p.NSP, "err", err)
+			return c.sendError(p.NSP, err)
+		}
+		if c.protocol == ProtocolV5 && nsp.recovery.enabled() {
+			s.pid = newPid()
+		}
+	}
+
+	// The client gets the CONNECT and missed packets before any broadcast or event of the connection
+	// handler.
+	if err := s.sendConnect(); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.sockets[s.namespace] = s
+	c.lock.Unlock()
+	if recovered {
+		if err := s.takeOver(old, rooms); err != nil {
+			return err
+		}
+	} else if err := s.Join(allRoom); err != nil {
+		return err
+	}
+	nsp.add(s)
+	c.log().Info("socket connected", "sid", s.Id(), "nsp", s.namespace, "recovered", recovered)
+	nsp.watch.get().socketConnected(s)
+	s.socketHandler.onPacket(nil, p)
+	return nil
+}
+
+// close closes the connection on purpose, so its sockets aren't kept for recovery.
+func (c *conn) close() error {
+	c.lock.Lock()
+	c.closing = true
+	c.lock.Unlock()
+	return c.Close()
+}
+
+// sendError sends the ERROR packet of the rejected connection to namespace nsp. socket.io v4 clients
+// get the data of err, or the message if there is no data.
+func (c *conn) sendError(nsp string, err error) error {
+	e, ok := err.(*ConnectError)
+	if !ok {
+		e = &ConnectError{Message: err.Error()}
+	}
+	var data interface{}
+	if c.protocol == ProtocolV5 {
+		d := map[string]interface{}{"message": e.Message}
+		if e.Data != nil {
+			d["data"] = e.Data
+		}
+		data = d
+	} else if e.Data != nil {
+		data = e.Data
+	} else {
+		data = e.Message
+	}
+	return c.encode(packet{
+		Type: _ERROR,
+		Id:   -1,
+		NSP:  nsp,
+		Data: data,
+	})
+}
+
+// remove removes the socket s, and returns false if it was already removed.
+func (c *conn) remove(s *socket) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.sockets[s.namespace] != s {
+		return false
+	}
+	delete(c.sockets, s.namespace)
+	return true
+}
+
+// disconnect sends DISCONNECT for the socket s and removes it, closing the connection if it was the
+// last socket.
+func (c *conn) disconnect(s *socket) error {
+	if !c.remove(s) {
+		return nil
+	}
+	err := c.encode(packet{
+		Type: _DISCONNECT,
+		Id:   -1,
+		NSP:  s.namespace,
+	})
+	s.onDisconnect()
+	if c.empty() {
+		c.Close()
+	}
+	return err
+}
+
+// disconnectAll disconnects every socket, then closes the connection.
+func (c *conn) disconnectAll() {
+	c.lock.RLock()
+	sockets := make([]*socket, 0, len(c.sockets))
+	for _, s := range c.sockets {
+		sockets = append(sockets, s)
+	}
+	c.lock.RUnlock()
+	for _, s := range sockets {
+		c.disconnect(s)
+	}
+	c.close()
+}
+
+func (c *conn) empty() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.sockets) == 0
+}
@@ -0,0 +1,107 @@
+package socketio
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/pschlump/json" //	"encoding/json"
+)
+
+// store keeps the values attached to a socket. String keys are the ones of Socket.Set, others are
+// the typed context keys of SetContext.
+type store struct {
+	values map[interface{}]interface{}
+	lock   sync.RWMutex
+}
+
+func (s *store) set(key, val interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.values == nil {
+		s.values = make(map[interface{}]interface{})
+	}
+	s.values[key] = val
+}
+
+func (s *store) get(key interface{}) (interface{}, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	ret, ok := s.values[key]
+	return ret, ok
+}
+
+// copyFrom replaces the values with the ones of other.
+func (s *store) copyFrom(other *store) {
+	other.lock.RLock()
+	values := make(map[interface{}]interface{}, len(other.values))
+	for k, v := range other.values {
+		values[k] = v
+	}
+	other.lock.RUnlock()
+	s.lock.Lock()
+	s.values = values
+	s.lock.Unlock()
+}
+
+func (s *socket) Data() map[string]interface{} {
+	s.data.lock.RLock()
+	defer s.data.lock.RUnlock()
+	ret := make(map[string]interface{})
+	for k, v := range s.data.values {
+		if key, ok := k.(string); ok {
+			ret[key] = v
+		}
+	}
+	return ret
+}
+
+func (s *socket) Set(key string, val interface{}) {
+	s.data.set(key, val)
+}
+
+func (s *socket) Get(key string) interface{} {
+	ret, _ := s.data.get(key)
+	return ret
+}
+
+// contextKey is the key of the context of type T.
+type contextKey[T any] struct{}
+
+// SetContext attaches v to the socket so, as its context of type T. Each type has its own context,
+// which isn't in Socket.Data.
+func SetContext[T any](so Socket, v T) {
+	if s, ok := so.(*socket); ok {
+		s.data.set(contextKey[T]{}, v)
+	}
+}
+
+// ContextOf returns the context of type T attached to the socket so, or false if there is none.
+func ContextOf[T any](so Socket) (T, bool) {
+	var ret T
+	s, ok := so.(*socket)
+	if !ok {
+		return ret, false
+	}
+	v, ok := s.data.get(contextKey[T]{})
+	if !ok {
+		return ret, false
+	}
+	ret, ok = v.(T)
+	return ret, ok
+}
+
+// matchData returns true if the data of so has all the values of want. Values are compared as JSON,
+// so they match after being sent to other servers.
+func matchData(so Socket, want map[string]interface{}) bool {
+	for key, val := range want {
+		a, err := json.Marshal(so.Get(key))
+		if err != nil {
+			return false
+		}
+		b, err := json.Marshal(val)
+		if err != nil || !bytes.Equal(a, b) {
+			return false
+		}
+	}
+	return true
+}
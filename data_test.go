@@ -0,0 +1,34 @@
+package socketio
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSocketData(t *testing.T) {
+	Convey("Attach data to socket", t, func() {
+		s := &socket{}
+		So(s.Get("user"), ShouldBeNil)
+		s.Set("user", "alice")
+		s.Set("age", 30)
+		So(s.Get("user"), ShouldEqual, "alice")
+
+		type user struct {
+			Name string
+		}
+		_, ok := ContextOf[*user](s)
+		So(ok, ShouldBeFalse)
+		SetContext(s, &user{Name: "alice"})
+		u, ok := ContextOf[*user](s)
+		So(ok, ShouldBeTrue)
+		So(u.Name, ShouldEqual, "alice")
+		So(s.Data(), ShouldResemble, map[string]interface{}{"user": "alice", "age": 30})
+
+		Convey("match as JSON", func() {
+			So(matchData(s, map[string]interface{}{"user": "alice", "age": 30.0}), ShouldBeTrue)
+			So(matchData(s, map[string]interface{}{"user": "bob"}), ShouldBeFalse)
+			So(matchData(s, map[string]interface{}{"role": nil}), ShouldBeTrue)
+		})
+	})
+}
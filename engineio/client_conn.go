@@ -0,0 +1,385 @@
+package engineio
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+	"github.com/pschlump/socketio/engineio/message"
+	"github.com/pschlump/socketio/engineio/parser"
+	"github.com/pschlump/socketio/engineio/polling"
+	"github.com/pschlump/socketio/engineio/transport"
+	"github.com/pschlump/socketio/engineio/websocket"
+)
+
+type clientConn struct {
+	id              string
+	request         *http.Request
+	url             url.URL
+	header          http.Header
+	creaters        transportCreaters
+	writerLocker    sync.Mutex
+	transportLocker sync.RWMutex
+	upgradeLocker   sync.RWMutex
+	currentName     string
+	current         transport.Client
+	state           state
+	stateLocker     sync.RWMutex
+	readerChan      chan *connReader
+	closeChan       chan struct{}
+	closeOnce       sync.Once
+	pingTimeout     time.Duration
+	pingInterval    time.Duration
+	pingChan        chan bool
+}
+
+// Dial connects to the engine.io server at rawurl, for example "http://localhost:9000/socket.io/".
+// If transports is nil, client will use ["polling", "websocket"] as default, and upgrade to websocket
+// before returning when the server offers it. The header is sent with every request. The client
+// speaks engine.io v3, or v4 if the query of rawurl has EIO=4.
+func Dial(rawurl string, transports []string, header http.Header) (Conn, error) {
+	if transports == nil {
+		transports = []string{"polling", "websocket"}
+	}
+	creaters := make(transportCreaters)
+	for _, t := range transports {
+		switch t {
+		case "polling":
+			creaters[t] = polling.Creater
+		case "websocket":
+			creaters[t] = websocket.Creater
+		default:
+			return nil, InvalidError
+		}
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		header = make(http.Header)
+	}
+	ret := &clientConn{
+		url:        *u,
+		header:     header,
+		creaters:   creaters,
+		state:      stateNormal,
+		readerChan: make(chan *connReader),
+		closeChan:  make(chan struct{}),
+		pingChan:   make(chan bool, 1),
+	}
+
+	first := transports[0]
+	t, info, err := ret.handshake(first)
+	if err != nil {
+		return nil, err
+	}
+	ret.id = info.Sid
+	ret.pingInterval = info.PingInterval * time.Millisecond
+	ret.pingTimeout = info.PingTimeout * time.Millisecond
+	ret.setCurrent(first, t)
+
+	go ret.readLoop(t)
+
+	// Upgrade before returning, so no message of the caller is in flight on polling while the
+	// server switches transports.
+	if first != "websocket" {
+		if _, ok := creaters["websocket"]; ok {
+			for _, name := range info.Upgrades {
+				if name == "websocket" {
+					ret.upgrade(name)
+					break
+				}
+			}
+		}
+	}
+
+	go ret.pingLoop()
+
+	return ret, nil
+}
+
+func (c *clientConn) Id() string {
+	return c.id
+}
+
+func (c *clientConn) Request() *http.Request {
+	return c.request
+}
+
+func (c *clientConn) NextReader() (MessageType, io.ReadCloser, error) {
+	select {
+	case ret := <-c.readerChan:
+		return MessageType(ret.MessageType()), ret, nil
+	case <-c.closeChan:
+		return MessageBinary, nil, io.EOF
+	}
+}
+
+func (c *clientConn) NextWriter(t MessageType) (io.WriteCloser, error) {
+	if c.getState() != stateNormal {
+		return nil, io.EOF
+	}
+	c.writerLocker.Lock()
+	w, err := c.getCurrent().NextWriter(message.MessageType(t), parser.MESSAGE)
+	if err != nil {
+		c.writerLocker.Unlock()
+		return nil, err
+	}
+	return newConnWriter(w, &c.writerLocker), nil
+}
+
+func (c *clientConn) Close() error {
+	if c.getState() != stateNormal {
+		return nil
+	}
+	c.setState(stateClosing)
+	c.writerLocker.Lock()
+	if w, err := c.getCurrent().NextWriter(message.MessageText, parser.CLOSE); err == nil {
+		writer := newConnWriter(w, &c.writerLocker)
+		writer.Close()
+	} else {
+		c.writerLocker.Unlock()
+	}
+	err := c.getCurrent().Close()
+	c.onClose()
+	return err
+}
+
+// handshake opens transport name without a sid and reads the OPEN packet. Polling needs the sid
+// in every request after the handshake, so it gets the sid before it is returned. Messages sent
+// with the OPEN packet are left for the read loop.
+func (c *clientConn) handshake(name string) (transport.Client, *connectionInfo, error) {
+	t, err := c.newTransport(name, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	decoder, err := t.NextReader()
+	if err != nil {
+		t.Close()
+		return nil, nil, err
+	}
+	defer decoder.Close()
+	if decoder.Type() != parser.OPEN {
+		t.Close()
+		return nil, nil, fmt.Errorf("invalid handshake packet %s", decoder.Type())
+	}
+	var info connectionInfo
+	if err := json.NewDecoder(decoder).Decode(&info); err != nil {
+		t.Close()
+		return nil, nil, err
+	}
+	if s, ok := t.(sidSetter); ok {
+		s.SetSid(info.Sid)
+	}
+	return t, &info, nil
+}
+
+// sidSetter is the transport which needs the sid in every request after the handshake.
+type sidSetter interface {
+	SetSid(sid string)
+}
+
+func (c *clientConn) newTransport(name, sid string) (transport.Client, error) {
+	u := c.url
+	query := u.Query()
+	if query.Get("EIO") == "" {
+		query.Set("EIO", fmt.Sprintf("%d", parser.Protocol))
+	}
+	query.Set("transport", name)
+	if sid != "" {
+		query.Set("sid", sid)
+	}
+	u.RawQuery = query.Encode()
+	if name == "websocket" {
+		u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	if c.request == nil {
+		c.request = req
+	}
+	return c.creaters.Get(name).Client(req)
+}
+
+// upgrade probes the transport name and switches to it once the server answers the probe.
+// The read loop of the old transport waits on upgradeLocker, so it won't send a request the
+// server would take as another upgrade, or take the end of the old transport as a close.
+func (c *clientConn) upgrade(name string) {
+	c.upgradeLocker.Lock()
+	defer c.upgradeLocker.Unlock()
+
+	t, err := c.newTransport(name, c.id)
+	if err != nil {
+		return
+	}
+	w, err := t.NextWriter(message.MessageText, parser.PING)
+	if err != nil {
+		t.Close()
+		return
+	}
+	w.Write([]byte("probe"))
+	w.Close()
+	decoder, err := t.NextReader()
+	if err != nil {
+		t.Close()
+		return
+	}
+	ok := decoder.Type() == parser.PONG
+	decoder.Close()
+	if !ok || c.getState() != stateNormal {
+		t.Close()
+		return
+	}
+
+	c.writerLocker.Lock()
+	old := c.getCurrent()
+	if w, err := t.NextWriter(message.MessageText, parser.UPGRADE); err == nil {
+		w.Close()
+	}
+	c.setCurrent(name, t)
+	c.writerLocker.Unlock()
+
+	old.Close()
+	go c.readLoop(t)
+}
+
+func (c *clientConn) readLoop(t transport.Client) {
+	for {
+		c.upgradeLocker.RLock()
+		c.upgradeLocker.RUnlock()
+		if c.getCurrent() != t {
+			return
+		}
+		decoder, err := t.NextReader()
+		if err != nil {
+			c.upgradeLocker.RLock()
+			current := c.getCurrent()
+			c.upgradeLocker.RUnlock()
+			if current == t {
+				t.Close()
+				c.onClose()
+			}
+			return
+		}
+		c.onPacket(t, decoder)
+		// A reader left open by the user may still be read after Close, so stop here rather than
+		// reading the transport under it.
+		if c.getState() == stateClosed {
+			return
+		}
+	}
+}
+
+func (c *clientConn) onPacket(t transport.Client, r *parser.PacketDecoder) {
+	defer r.Close()
+	select {
+	case c.pingChan <- true:
+	default:
+	}
+	switch r.Type() {
+	case parser.CLOSE:
+		t.Close()
+		c.onClose()
+	case parser.PING:
+		c.writerLocker.Lock()
+		if w, _ := t.NextWriter(message.MessageText, parser.PONG); w != nil {
+			io.Copy(w, r)
+			w.Close()
+		}
+		c.writerLocker.Unlock()
+	case parser.MESSAGE:
+		closeChan := make(chan struct{}, 1)
+		select {
+		case c.readerChan <- newConnReader(r, closeChan):
+		case <-c.closeChan:
+			return
+		}
+		select {
+		case <-closeChan:
+		case <-c.closeChan:
+		}
+	case parser.OPEN, parser.PONG, parser.UPGRADE, parser.NOOP:
+	}
+}
+
+func (c *clientConn) onClose() {
+	c.closeOnce.Do(func() {
+		c.setState(stateClosed)
+		close(c.closeChan)
+	})
+}
+
+func (c *clientConn) getCurrent() transport.Client {
+	c.transportLocker.RLock()
+	defer c.transportLocker.RUnlock()
+
+	return c.current
+}
+
+func (c *clientConn) getCurrentName() string {
+	c.transportLocker.RLock()
+	defer c.transportLocker.RUnlock()
+
+	return c.currentName
+}
+
+func (c *clientConn) setCurrent(name string, t transport.Client) {
+	c.transportLocker.Lock()
+	defer c.transportLocker.Unlock()
+
+	c.currentName = name
+	c.current = t
+}
+
+func (c *clientConn) getState() state {
+	c.stateLocker.RLock()
+	defer c.stateLocker.RUnlock()
+	return c.state
+}
+
+func (c *clientConn) setState(state state) {
+	c.stateLocker.Lock()
+	defer c.stateLocker.Unlock()
+	c.state = state
+}
+
+func (c *clientConn) pingLoop() {
+	interval := c.pingInterval
+	if interval <= 0 {
+		interval = 25000 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPing := time.Now()
+	for {
+		select {
+		case <-c.closeChan:
+			return
+		case <-c.pingChan:
+			lastPing = time.Now()
+		case <-ticker.C:
+			if time.Since(lastPing) > interval+c.pingTimeout {
+				c.Close()
+				return
+			}
+			c.writerLocker.Lock()
+			if w, _ := c.getCurrent().NextWriter(message.MessageText, parser.PING); w != nil {
+				writer := newConnWriter(w, &c.writerLocker)
+				writer.Close()
+			} else {
+				c.writerLocker.Unlock()
+			}
+		}
+	}
+}
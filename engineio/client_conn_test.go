@@ -0,0 +1,90 @@
+package engineio
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientConn(t *testing.T) {
+	Convey("Dial server", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		accept := make(chan Conn, 1)
+		go func() {
+			conn, _ := server.Accept()
+			accept <- conn
+		}()
+
+		Convey("with invalid transport", func() {
+			_, err := Dial(h.URL, []string{"carrier pigeon"}, nil)
+			So(err, ShouldEqual, InvalidError)
+		})
+
+		Convey("polling then upgrade", func() {
+			client, err := Dial(h.URL, nil, nil)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(client.Id(), ShouldNotEqual, "")
+
+			conn := <-accept
+			So(conn.Id(), ShouldEqual, client.Id())
+
+			received := make(chan string, 1)
+			go func() {
+				_, r, err := conn.NextReader()
+				if err != nil {
+					received <- err.Error()
+					return
+				}
+				b, _ := ioutil.ReadAll(r)
+				r.Close()
+				received <- string(b)
+			}()
+
+			w, err := client.NextWriter(MessageText)
+			So(err, ShouldBeNil)
+			_, err = w.Write([]byte("hello"))
+			So(err, ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+			So(<-received, ShouldEqual, "hello")
+
+			for i := 0; i < 20 && client.(*clientConn).getCurrentName() != "websocket"; i++ {
+				time.Sleep(50 * time.Millisecond)
+			}
+			So(client.(*clientConn).getCurrentName(), ShouldEqual, "websocket")
+
+			w, err = conn.NextWriter(MessageBinary)
+			So(err, ShouldBeNil)
+			_, err = w.Write([]byte{1, 2, 3})
+			So(err, ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+
+			typ, r, err := client.NextReader()
+			So(err, ShouldBeNil)
+			So(typ, ShouldEqual, MessageBinary)
+			b, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, []byte{1, 2, 3})
+			r.Close()
+		})
+
+		Convey("websocket only", func() {
+			client, err := Dial(h.URL, []string{"websocket"}, nil)
+			So(err, ShouldBeNil)
+
+			conn := <-accept
+			So(conn.Id(), ShouldEqual, client.Id())
+
+			So(client.Close(), ShouldBeNil)
+			_, _, err = client.NextReader()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,53 @@
+package engineio
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pschlump/socketio/engineio/transport"
+)
+
+// CORS configures the cross-origin requests which browsers make for long-polling when the page
+// comes from another site than the server.
+type CORS struct {
+	// AllowedOrigins are the origins which may connect, like "https://app.example.com". A "*"
+	// matches any part of an origin, e.g. "https://*.example.com".
+	AllowedOrigins []string
+
+	AllowCredentials bool          // AllowCredentials lets the requests carry cookies.
+	AllowedHeaders   []string      // AllowedHeaders are the request headers allowed besides the simple ones.
+	MaxAge           time.Duration // MaxAge is how long browsers may cache a preflight, 0 leaves it to them.
+}
+
+// apply sets the CORS headers of the response to r if its origin is allowed. It answers preflight
+// requests, and returns true if r is one.
+func (c *CORS) apply(w http.ResponseWriter, r *http.Request) bool {
+	preflight := r.Method == "OPTIONS"
+	origin := r.Header.Get("Origin")
+	if origin == "" || !transport.MatchOrigin(c.AllowedOrigins, origin) {
+		if preflight {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+		}
+		return preflight
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Add("Vary", "Origin")
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if len(c.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
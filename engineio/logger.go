@@ -0,0 +1,13 @@
+package engineio
+
+// Logger receives the errors of a Server which no caller gets, like the failures of its
+// SessionRegistry. keyvals are alternating keys and values, like "sid", id. The Logger of socket.io
+// is a Logger.
+type Logger interface {
+	Error(msg string, keyvals ...interface{})
+}
+
+// nopLogger drops every record, it's the default Logger.
+type nopLogger struct{}
+
+func (nopLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,27 @@
+package engineio
+
+// Metrics receives the measurements of the connections of a Server, see Server.SetMetrics. Methods
+// are called from several goroutines.
+type Metrics interface {
+
+	// ConnectionOpened is called when a handshake over transport succeeds.
+	ConnectionOpened(transport string)
+
+	// ConnectionClosed is called when a connection whose current transport is transport closes.
+	ConnectionClosed(transport string)
+
+	// ConnectionUpgraded is called when a connection switches from transport from to transport to.
+	ConnectionUpgraded(from, to string)
+
+	// HandshakeRejected is called when a handshake is refused, reason is "allow_request",
+	// "max_connection", "server_closed" or "registry".
+	HandshakeRejected(reason string)
+}
+
+// nopMetrics drops every measurement, it's the default Metrics.
+type nopMetrics struct{}
+
+func (nopMetrics) ConnectionOpened(string)           {}
+func (nopMetrics) ConnectionClosed(string)           {}
+func (nopMetrics) ConnectionUpgraded(string, string) {}
+func (nopMetrics) HandshakeRejected(string)          {}
@@ -103,6 +103,31 @@ func NewB64Encoder(w io.Writer, t PacketType) (*PacketEncoder, error) {
 	}, nil
 }
 
+// NewV4BinaryEncoder return the encoder which encode binary message to writer w, as engine.io v4 does. There
+// is no packet type in v4 binary frames, so t is not written and the packet is always a message.
+func NewV4BinaryEncoder(w io.Writer, t PacketType) (*PacketEncoder, error) {
+	closer, ok := w.(io.Closer)
+	if !ok {
+		closer = nil
+	}
+	return &PacketEncoder{
+		closer: closer,
+		w:      w,
+	}, nil
+}
+
+// newV4B64Encoder return the encoder which encode binary message to writer w, as engine.io v4 payload does.
+func newV4B64Encoder(w io.Writer) (*PacketEncoder, error) {
+	if _, err := w.Write([]byte{'b'}); err != nil {
+		return nil, err
+	}
+	base := base64.NewEncoder(base64.StdEncoding, w)
+	return &PacketEncoder{
+		closer: base,
+		w:      base,
+	}, nil
+}
+
 // Write writes bytes p.
 func (e *PacketEncoder) Write(p []byte) (int, error) {
 	return e.w.Write(p)
@@ -167,6 +192,20 @@ func NewDecoder(r io.Reader) (*PacketDecoder, error) {
 	return ret, nil
 }
 
+// NewV4BinaryDecoder return the decoder which decode binary message from reader r, as engine.io v4 sends it.
+func NewV4BinaryDecoder(r io.Reader) (*PacketDecoder, error) {
+	var closer io.Closer
+	if limit, ok := r.(*limitReader); ok {
+		closer = limit
+	}
+	return &PacketDecoder{
+		closer:  closer,
+		r:       r,
+		t:       MESSAGE,
+		msgType: message.MessageBinary,
+	}, nil
+}
+
 // Read reads packet data to bytes p.
 func (d *PacketDecoder) Read(p []byte) (int, error) {
 	return d.r.Read(p)
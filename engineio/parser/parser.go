@@ -1,3 +1,8 @@
 package parser
 
-const Protocol = 3
+// Protocol is the revision of engine.io protocol which uses length prefixed payloads. ProtocolV4 is the
+// revision used by engine.io-client 4.x and later, which separates payload packets with a record separator.
+const (
+	Protocol   = 3
+	ProtocolV4 = 4
+)
@@ -3,17 +3,22 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"strconv"
 	"sync"
 )
 
+// recordSeparator separates the packets of an engine.io v4 payload.
+const recordSeparator = 0x1e
+
 // payloadEncoder is the encoder to encode packets as payload. It can be used in multi-thread.
 type PayloadEncoder struct {
 	buffers  [][]byte
 	locker   sync.Mutex
 	isString bool
+	v4       bool
 }
 
 // NewStringPayloadEncoder returns the encoder which encode as string.
@@ -30,6 +35,15 @@ func NewBinaryPayloadEncoder() *PayloadEncoder {
 	}
 }
 
+// NewV4PayloadEncoder returns the encoder which encode as engine.io v4 does, as string with packets separated by
+// a record separator and binary encoded in base64.
+func NewV4PayloadEncoder() *PayloadEncoder {
+	return &PayloadEncoder{
+		isString: true,
+		v4:       true,
+	}
+}
+
 type encoder struct {
 	*PacketEncoder
 	buf          *bytes.Buffer
@@ -42,7 +56,9 @@ func (e encoder) Close() error {
 		return err
 	}
 	var buffer []byte
-	if e.payload.isString {
+	if e.payload.v4 {
+		buffer = e.buf.Bytes()
+	} else if e.payload.isString {
 		buffer = []byte(fmt.Sprintf("%d:%s", e.buf.Len(), e.buf.String()))
 	} else {
 		buffer = []byte(fmt.Sprintf("%s%d", e.binaryPrefix, e.buf.Len()))
@@ -80,7 +96,9 @@ func (e *PayloadEncoder) NextBinary(t PacketType) (io.WriteCloser, error) {
 	buf := bytes.NewBuffer(nil)
 	var pEncoder *PacketEncoder
 	var err error
-	if e.isString {
+	if e.v4 {
+		pEncoder, err = newV4B64Encoder(buf)
+	} else if e.isString {
 		pEncoder, err = NewB64Encoder(buf, t)
 	} else {
 		pEncoder, err = NewBinaryEncoder(buf, t)
@@ -103,7 +121,12 @@ func (e *PayloadEncoder) EncodeTo(w io.Writer) error {
 	e.buffers = nil
 	e.locker.Unlock()
 
-	for _, b := range buffers {
+	for i, b := range buffers {
+		if e.v4 && i > 0 {
+			if _, err := w.Write([]byte{recordSeparator}); err != nil {
+				return err
+			}
+		}
 		for len(b) > 0 {
 			n, err := w.Write(b)
 			if err != nil {
@@ -115,6 +138,13 @@ func (e *PayloadEncoder) EncodeTo(w io.Writer) error {
 	return nil
 }
 
+// Len returns the number of packets waiting to be encoded.
+func (e *PayloadEncoder) Len() int {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	return len(e.buffers)
+}
+
 //IsString returns true if payload encode to string, otherwise returns false.
 func (e *PayloadEncoder) IsString() bool {
 	return e.isString
@@ -122,7 +152,8 @@ func (e *PayloadEncoder) IsString() bool {
 
 // payloadDecoder is the decoder to decode payload.
 type PayloadDecoder struct {
-	r *bufio.Reader
+	r  *bufio.Reader
+	v4 bool
 }
 
 // NewPaylaodDecoder returns the payload decoder which read from reader r.
@@ -136,8 +167,18 @@ func NewPayloadDecoder(r io.Reader) *PayloadDecoder {
 	}
 }
 
+// NewV4PayloadDecoder returns the payload decoder which read engine.io v4 payload from reader r.
+func NewV4PayloadDecoder(r io.Reader) *PayloadDecoder {
+	ret := NewPayloadDecoder(r)
+	ret.v4 = true
+	return ret
+}
+
 // Next returns the packet decoder. Make sure it will be closed after used.
 func (d *PayloadDecoder) Next() (*PacketDecoder, error) {
+	if d.v4 {
+		return d.nextV4()
+	}
 	firstByte, err := d.r.Peek(1)
 	if err != nil {
 		return nil, err
@@ -168,3 +209,23 @@ func (d *PayloadDecoder) Next() (*PacketDecoder, error) {
 	}
 	return NewDecoder(newLimitReader(d.r, int(packetLen)))
 }
+
+func (d *PayloadDecoder) nextV4() (*PacketDecoder, error) {
+	record, err := d.r.ReadBytes(recordSeparator)
+	if err == io.EOF {
+		if len(record) == 0 {
+			return nil, io.EOF
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		record = record[:len(record)-1]
+	}
+	if len(record) == 0 {
+		return nil, fmt.Errorf("invalid input")
+	}
+	if record[0] == 'b' {
+		return NewV4BinaryDecoder(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(record[1:])))
+	}
+	return NewDecoder(bytes.NewReader(record))
+}
@@ -171,6 +171,87 @@ func TestBinaryPayload(t *testing.T) {
 	}
 }
 
+func TestV4Payload(t *testing.T) {
+	type packet struct {
+		Type     PacketType
+		Data     []byte
+		IsString bool
+	}
+	type Test struct {
+		name    string
+		packets []packet
+		output  string
+	}
+	var tests = []Test{
+		{"all in one", []packet{packet{OPEN, nil, true}, packet{MESSAGE, []byte("测试"), true}, packet{MESSAGE, []byte("测试"), false}}, "0\x1e4\xe6\xb5\x8b\xe8\xaf\x95\x1eb5rWL6K+V"},
+	}
+	for _, test := range tests {
+		buf := bytes.NewBuffer(nil)
+
+		Convey("Given an array of packet "+test.name, t, func() {
+
+			Convey("Create encoder", func() {
+				encoder := NewV4PayloadEncoder()
+				So(encoder.IsString(), ShouldBeTrue)
+
+				Convey("Encoded", func() {
+					for _, p := range test.packets {
+						var e io.WriteCloser
+						var err error
+						if p.IsString {
+							e, err = encoder.NextString(p.Type)
+						} else {
+							e, err = encoder.NextBinary(p.Type)
+						}
+						So(err, ShouldBeNil)
+						for d := p.Data; len(d) > 0; {
+							n, err := e.Write(d)
+							So(err, ShouldBeNil)
+							d = d[n:]
+						}
+						err = e.Close()
+						So(err, ShouldBeNil)
+					}
+
+					Convey("End", func() {
+						err := encoder.EncodeTo(buf)
+						So(err, ShouldBeNil)
+						So(buf.String(), ShouldEqual, test.output)
+					})
+				})
+			})
+
+			Convey("Create decoder", func() {
+				decoder := NewV4PayloadDecoder(buf)
+
+				Convey("Decode", func() {
+					for i := 0; ; i++ {
+						d, err := decoder.Next()
+						if err == io.EOF {
+							break
+						}
+						So(err, ShouldBeNil)
+						So(d.Type(), ShouldEqual, test.packets[i].Type)
+
+						if l := len(test.packets[i].Data); l > 0 {
+							buf := make([]byte, len(test.packets[i].Data)+1)
+							n, err := d.Read(buf)
+							if n > 0 {
+								So(err, ShouldBeNil)
+								So(buf[:n], ShouldResemble, test.packets[i].Data)
+							}
+							_, err = d.Read(buf)
+							So(err, ShouldEqual, io.EOF)
+						}
+						err = d.Close()
+						So(err, ShouldBeNil)
+					}
+				})
+			})
+		})
+	}
+}
+
 func TestParallelEncode(t *testing.T) {
 	prev := runtime.GOMAXPROCS(10)
 	defer runtime.GOMAXPROCS(prev)
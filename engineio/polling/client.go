@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/pschlump/socketio/engineio/message"
@@ -15,9 +16,11 @@ import (
 )
 
 type client struct {
+	v4             bool
 	req            http.Request
 	url            url.URL
 	seq            uint
+	seqLocker      sync.Mutex
 	getResp        *http.Response
 	postResp       *http.Response
 	resp           *http.Response
@@ -25,14 +28,19 @@ type client struct {
 	payloadEncoder *parser.PayloadEncoder
 	client         *http.Client
 	state          state
+	stateLocker    sync.RWMutex
 }
 
 func NewClient(r *http.Request) (transport.Client, error) {
+	v4 := r.URL.Query().Get("EIO") == "4"
 	newEncoder := parser.NewBinaryPayloadEncoder
-	if _, ok := r.URL.Query()["b64"]; ok {
+	if v4 {
+		newEncoder = parser.NewV4PayloadEncoder
+	} else if _, ok := r.URL.Query()["b64"]; ok {
 		newEncoder = parser.NewStringPayloadEncoder
 	}
 	ret := &client{
+		v4:             v4,
 		req:            *r,
 		url:            *r.URL,
 		seq:            0,
@@ -43,12 +51,20 @@ func NewClient(r *http.Request) (transport.Client, error) {
 	return ret, nil
 }
 
+// SetSid adds the sid of the handshake to the following requests. The rest of the handshake payload
+// is still read by NextReader.
+func (c *client) SetSid(sid string) {
+	query := c.url.Query()
+	query.Set("sid", sid)
+	c.url.RawQuery = query.Encode()
+}
+
 func (c *client) Response() *http.Response {
 	return c.resp
 }
 
 func (c *client) NextReader() (*parser.PacketDecoder, error) {
-	if c.state != stateNormal {
+	if c.getState() != stateNormal {
 		return nil, io.EOF
 	}
 	if c.payloadDecoder != nil {
@@ -69,12 +85,16 @@ func (c *client) NextReader() (*parser.PacketDecoder, error) {
 	if c.resp == nil {
 		c.resp = c.getResp
 	}
-	c.payloadDecoder = parser.NewPayloadDecoder(c.getResp.Body)
+	if c.v4 {
+		c.payloadDecoder = parser.NewV4PayloadDecoder(c.getResp.Body)
+	} else {
+		c.payloadDecoder = parser.NewPayloadDecoder(c.getResp.Body)
+	}
 	return c.payloadDecoder.Next()
 }
 
 func (c *client) NextWriter(messageType message.MessageType, packetType parser.PacketType) (io.WriteCloser, error) {
-	if c.state != stateNormal {
+	if c.getState() != stateNormal {
 		return nil, io.EOF
 	}
 	next := c.payloadEncoder.NextBinary
@@ -89,6 +109,8 @@ func (c *client) NextWriter(messageType message.MessageType, packetType parser.P
 }
 
 func (c *client) Close() error {
+	c.stateLocker.Lock()
+	defer c.stateLocker.Unlock()
 	if c.state != stateNormal {
 		return nil
 	}
@@ -96,19 +118,27 @@ func (c *client) Close() error {
 	return nil
 }
 
+func (c *client) getState() state {
+	c.stateLocker.RLock()
+	defer c.stateLocker.RUnlock()
+	return c.state
+}
+
 func (c *client) getReq() *http.Request {
 	req := c.req
 	url := c.url
 	req.URL = &url
 	query := req.URL.Query()
+	c.seqLocker.Lock()
 	query.Set("t", fmt.Sprintf("%d-%d", time.Now().Unix()*1000, c.seq))
 	c.seq++
+	c.seqLocker.Unlock()
 	req.URL.RawQuery = query.Encode()
 	return &req
 }
 
 func (c *client) doPost() error {
-	if c.state != stateNormal {
+	if c.getState() != stateNormal {
 		return io.EOF
 	}
 	req := c.getReq()
@@ -123,6 +153,11 @@ func (c *client) doPost() error {
 	if err != nil {
 		return err
 	}
+	io.Copy(ioutil.Discard, c.postResp.Body)
+	c.postResp.Body.Close()
+	if c.postResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("post failed: %s", c.postResp.Status)
+	}
 	if c.resp == nil {
 		c.resp = c.postResp
 	}
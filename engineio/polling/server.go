@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"sync"
 
@@ -22,21 +23,27 @@ const (
 )
 
 type Polling struct {
+	v4          bool
 	sendChan    chan bool
 	encoder     *parser.PayloadEncoder
 	callback    transport.Callback
 	getLocker   *Locker
 	postLocker  *Locker
 	state       state
+	waiting     bool // waiting is true while a GET waits for messages.
 	stateLocker sync.Mutex
 }
 
 func NewServer(w http.ResponseWriter, r *http.Request, callback transport.Callback) (transport.Server, error) {
+	v4 := r.URL.Query().Get("EIO") == "4"
 	newEncoder := parser.NewBinaryPayloadEncoder
-	if r.URL.Query()["b64"] != nil {
+	if v4 {
+		newEncoder = parser.NewV4PayloadEncoder
+	} else if r.URL.Query()["b64"] != nil {
 		newEncoder = parser.NewStringPayloadEncoder
 	}
 	ret := &Polling{
+		v4:         v4,
 		sendChan:   MakeSendChan(),
 		encoder:    newEncoder(),
 		callback:   callback,
@@ -93,6 +100,50 @@ func (p *Polling) NextWriter(msgType message.MessageType, packetType parser.Pack
 	return NewWriter(ret, p), nil
 }
 
+// Holding returns true while written messages wait for a GET.
+func (p *Polling) Holding() bool {
+	return p.encoder.Len() > 0
+}
+
+// Waiting returns true while a GET waits for messages, so they reach the client at once.
+func (p *Polling) Waiting() bool {
+	p.stateLocker.Lock()
+	defer p.stateLocker.Unlock()
+	return p.waiting
+}
+
+// FlushTo writes the messages which no GET has taken yet to the transport t, so they aren't lost
+// when upgrading to t.
+func (p *Polling) FlushTo(t transport.Server) error {
+	buf := bytes.Buffer{}
+	if err := p.encoder.EncodeTo(&buf); err != nil {
+		return err
+	}
+	decoder := parser.NewPayloadDecoder(&buf)
+	if p.v4 {
+		decoder = parser.NewV4PayloadDecoder(&buf)
+	}
+	for {
+		d, err := decoder.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.Type() == parser.MESSAGE {
+			w, err := t.NextWriter(d.MessageType(), parser.MESSAGE)
+			if err != nil {
+				d.Close()
+				return err
+			}
+			io.Copy(w, d)
+			w.Close()
+		}
+		d.Close()
+	}
+}
+
 func (p *Polling) get(w http.ResponseWriter, r *http.Request) {
 	if !p.getLocker.TryLock() {
 		http.Error(w, "overlay get", http.StatusBadRequest)
@@ -114,7 +165,9 @@ func (p *Polling) get(w http.ResponseWriter, r *http.Request) {
 		p.getLocker.Unlock()
 	}()
 
+	p.setWaiting(true)
 	<-p.sendChan
+	p.setWaiting(false)
 
 	if j := r.URL.Query().Get("j"); j != "" {
 		// JSONP Polling
@@ -134,6 +187,9 @@ func (p *Polling) get(w http.ResponseWriter, r *http.Request) {
 		}
 		p.encoder.EncodeTo(w)
 	}
+	if d, ok := p.callback.(transport.DrainCallback); ok {
+		d.OnDrain()
+	}
 
 }
 
@@ -159,20 +215,42 @@ func (p *Polling) post(w http.ResponseWriter, r *http.Request) {
 		p.postLocker.Unlock()
 	}()
 
+	var body io.Reader = r.Body
+	if n := transport.MaxBufferSize(p.callback); n > 0 {
+		if r.ContentLength > n {
+			p.tooLarge(w)
+			return
+		}
+		body = transport.LimitReader(r.Body, n)
+		r.Body = ioutil.NopCloser(body)
+	}
+
 	var decoder *parser.PayloadDecoder
 	if j := r.URL.Query().Get("j"); j != "" {
 		// JSONP Polling
+		if err := r.ParseForm(); err == transport.PayloadTooLargeError {
+			p.tooLarge(w)
+			return
+		}
 		d := r.FormValue("d")
 		decoder = parser.NewPayloadDecoder(bytes.NewBufferString(d))
 	} else {
 		// XHR Polling
-		decoder = parser.NewPayloadDecoder(r.Body)
+		if p.v4 {
+			decoder = parser.NewV4PayloadDecoder(body)
+		} else {
+			decoder = parser.NewPayloadDecoder(body)
+		}
 	}
 	for {
 		d, err := decoder.Next()
 		if err == io.EOF {
 			break
 		}
+		if err == transport.PayloadTooLargeError {
+			p.tooLarge(w)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -184,12 +262,24 @@ func (p *Polling) post(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("ok"))
 }
 
+// tooLarge answers a post whose body is over the max buffer size, and closes the connection.
+func (p *Polling) tooLarge(w http.ResponseWriter) {
+	http.Error(w, transport.PayloadTooLargeError.Error(), http.StatusRequestEntityTooLarge)
+	p.Close()
+}
+
 func (p *Polling) setState(s state) {
 	p.stateLocker.Lock()
 	defer p.stateLocker.Unlock()
 	p.state = s
 }
 
+func (p *Polling) setWaiting(w bool) {
+	p.stateLocker.Lock()
+	defer p.stateLocker.Unlock()
+	p.waiting = w
+}
+
 func (p *Polling) getState() state {
 	p.stateLocker.Lock()
 	defer p.stateLocker.Unlock()
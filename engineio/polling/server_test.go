@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -286,6 +287,22 @@ func TestServer(t *testing.T) {
 
 		})
 
+		Convey("Post over the limit", func() {
+			f := &limitCallback{fakeCallback: newFakeCallback(), max: 10}
+			r, err := http.NewRequest("GET", "/?EIO=4", nil)
+			So(err, ShouldBeNil)
+			server, err := NewServer(httptest.NewRecorder(), r, f)
+			So(err, ShouldBeNil)
+
+			w := httptest.NewRecorder()
+			r, err = http.NewRequest("POST", "/?EIO=4", ioutil.NopCloser(strings.NewReader("4message over the limit")))
+			So(err, ShouldBeNil)
+			server.ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(w.Body.String(), ShouldEqual, "payload too large\n")
+			So(f.ClosedCount(), ShouldEqual, 1)
+		})
+
 		Convey("Closing", func() {
 			Convey("No get no post", func() {
 				f := newFakeCallback()
@@ -506,3 +523,13 @@ func (f *fakeCallback) ClosedCount() int {
 	defer f.countLocker.Unlock()
 	return f.closedCount
 }
+
+// limitCallback is the fakeCallback which limits the size of posts to max.
+type limitCallback struct {
+	*fakeCallback
+	max int64
+}
+
+func (f *limitCallback) MaxHTTPBufferSize() int64 {
+	return f.max
+}
@@ -0,0 +1,149 @@
+package engineio
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// QueuePolicy decides what happens to a message sent to a connection whose outbound queue is full.
+type QueuePolicy int
+
+const (
+	DropOldest QueuePolicy = iota // DropOldest drops the oldest queued message to make room.
+	DropNewest                    // DropNewest drops the message being sent.
+	Disconnect                    // Disconnect closes the connection of the slow client.
+)
+
+// QueueFullError is returned when a message is sent to a connection whose queue is full, with the
+// Disconnect policy.
+var QueueFullError = errors.New("outbound queue full")
+
+// NotWritableError is returned by the writer of NextVolatileWriter when the message is dropped.
+var NotWritableError = errors.New("connection not writable")
+
+type queuedMessage struct {
+	typ  MessageType
+	data []byte
+}
+
+// sendQueue holds the messages written to a connection until its transport takes them. It holds at
+// most size text messages, 0 for no limit. Binary messages are the attachments of the text message
+// before them, which are kept or dropped with it.
+type sendQueue struct {
+	messages []queuedMessage
+	texts    int
+	size     int
+	policy   QueuePolicy
+	dropping bool // dropping is true while the attachments of a dropped message arrive.
+	lock     sync.Mutex
+	notify   chan struct{}
+}
+
+func newSendQueue(size int, policy QueuePolicy) *sendQueue {
+	return &sendQueue{
+		size:   size,
+		policy: policy,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push queues m. It returns QueueFullError if the queue is full and its policy is Disconnect.
+func (q *sendQueue) push(m queuedMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if m.typ == MessageText {
+		q.dropping = false
+		if q.size > 0 && q.texts >= q.size {
+			switch q.policy {
+			case DropOldest:
+				q.dropOldest()
+			case DropNewest:
+				q.dropping = true
+				return nil
+			default:
+				q.dropping = true
+				return QueueFullError
+			}
+		}
+		q.texts++
+	} else if q.dropping {
+		return nil
+	}
+	q.messages = append(q.messages, m)
+	q.wake()
+	return nil
+}
+
+// dropOldest drops the first text message and its attachments. Attachments before it belong to a
+// message the transport has taken already, so they are kept.
+func (q *sendQueue) dropOldest() {
+	i := 0
+	for i < len(q.messages) && q.messages[i].typ != MessageText {
+		i++
+	}
+	j := i + 1
+	for j < len(q.messages) && q.messages[j].typ != MessageText {
+		j++
+	}
+	q.messages = append(q.messages[:i], q.messages[j:]...)
+	q.texts--
+}
+
+// take returns the queued messages and empties the queue.
+func (q *sendQueue) take() []queuedMessage {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ret := q.messages
+	q.messages = nil
+	q.texts = 0
+	return ret
+}
+
+// pushFront puts ms, which were taken but not written, back before the queued messages.
+func (q *sendQueue) pushFront(ms []queuedMessage) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, m := range ms {
+		if m.typ == MessageText {
+			q.texts++
+		}
+	}
+	q.messages = append(ms[:len(ms):len(ms)], q.messages...)
+}
+
+// empty returns true if no message is queued.
+func (q *sendQueue) empty() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.messages) == 0
+}
+
+// wake tells the writer of the connection to look at the queue.
+func (q *sendQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// queueWriter buffers a message, which is queued when the writer is closed, or written at once if it
+// is volatile.
+type queueWriter struct {
+	bytes.Buffer
+	typ      MessageType
+	conn     *serverConn
+	volatile bool
+}
+
+func (w *queueWriter) Close() error {
+	if w.volatile {
+		return w.conn.writeVolatile(queuedMessage{typ: w.typ, data: w.Bytes()})
+	}
+	err := w.conn.queue.push(queuedMessage{typ: w.typ, data: w.Bytes()})
+	if err == QueueFullError {
+		// The transport may be stuck writing to the client, closing it unblocks the writer.
+		go w.conn.getCurrent().Close()
+	}
+	return err
+}
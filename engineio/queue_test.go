@@ -0,0 +1,126 @@
+package engineio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pschlump/socketio/engineio/transport"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSendQueue(t *testing.T) {
+	text := func(s string) queuedMessage { return queuedMessage{typ: MessageText, data: []byte(s)} }
+	binary := func(s string) queuedMessage { return queuedMessage{typ: MessageBinary, data: []byte(s)} }
+	data := func(q *sendQueue) []string {
+		var ret []string
+		for _, m := range q.take() {
+			ret = append(ret, string(m.data))
+		}
+		return ret
+	}
+
+	Convey("Drop oldest with its attachments", t, func() {
+		q := newSendQueue(2, DropOldest)
+		So(q.push(binary("sent")), ShouldBeNil)
+		So(q.push(text("a")), ShouldBeNil)
+		So(q.push(binary("a0")), ShouldBeNil)
+		So(q.push(text("b")), ShouldBeNil)
+		So(q.push(text("c")), ShouldBeNil)
+		So(q.push(binary("c0")), ShouldBeNil)
+		So(data(q), ShouldResemble, []string{"sent", "b", "c", "c0"})
+	})
+
+	Convey("Drop newest with its attachments", t, func() {
+		q := newSendQueue(1, DropNewest)
+		So(q.push(text("a")), ShouldBeNil)
+		So(q.push(text("b")), ShouldBeNil)
+		So(q.push(binary("b0")), ShouldBeNil)
+		So(data(q), ShouldResemble, []string{"a"})
+		So(q.push(text("c")), ShouldBeNil)
+		So(data(q), ShouldResemble, []string{"c"})
+	})
+
+	Convey("Put back messages which weren't written", t, func() {
+		q := newSendQueue(2, DropNewest)
+		So(q.push(text("a")), ShouldBeNil)
+		taken := q.take()
+		So(q.push(text("b")), ShouldBeNil)
+		q.pushFront(taken)
+		So(q.push(text("c")), ShouldBeNil)
+		So(data(q), ShouldResemble, []string{"a", "b"})
+	})
+
+	Convey("Disconnect", t, func() {
+		q := newSendQueue(1, Disconnect)
+		So(q.push(text("a")), ShouldBeNil)
+		So(q.push(text("b")), ShouldEqual, QueueFullError)
+	})
+
+	Convey("Hold messages while polling has some", t, func() {
+		server := newFakeServer()
+		server.config.QueueSize = 2
+		server.config.QueuePolicy = DropOldest
+		req, err := http.NewRequest("GET", "/?transport=polling&EIO=4", nil)
+		So(err, ShouldBeNil)
+		conn, err := newServerConn("id", httptest.NewRecorder(), req, server)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		get := func() string {
+			resp := httptest.NewRecorder()
+			conn.ServeHTTP(resp, req)
+			return resp.Body.String()
+		}
+		send := func(s string) {
+			w, err := conn.NextWriter(MessageText)
+			So(err, ShouldBeNil)
+			w.Write([]byte(s))
+			So(w.Close(), ShouldBeNil)
+		}
+		So(get(), ShouldStartWith, "0{")
+
+		send("1")
+		for !conn.getCurrent().(transport.Holder).Holding() {
+			time.Sleep(time.Millisecond)
+		}
+		for _, s := range []string{"2", "3", "4", "5"} {
+			send(s)
+		}
+		So(get(), ShouldEqual, "41")
+		So(get(), ShouldEqual, "44\x1e45")
+	})
+
+	Convey("Write volatile messages while a GET waits", t, func() {
+		server := newFakeServer()
+		req, err := http.NewRequest("GET", "/?transport=polling&EIO=4", nil)
+		So(err, ShouldBeNil)
+		conn, err := newServerConn("id", httptest.NewRecorder(), req, server)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		resp := httptest.NewRecorder()
+		conn.ServeHTTP(resp, req)
+		So(resp.Body.String(), ShouldStartWith, "0{")
+		volatile := func(s string) error {
+			w, err := conn.NextVolatileWriter(MessageText)
+			So(err, ShouldBeNil)
+			w.Write([]byte(s))
+			return w.Close()
+		}
+		So(volatile("0"), ShouldEqual, NotWritableError)
+
+		done := make(chan string)
+		go func() {
+			resp := httptest.NewRecorder()
+			conn.ServeHTTP(resp, req)
+			done <- resp.Body.String()
+		}()
+		for !conn.getCurrent().(transport.Holder).Waiting() {
+			time.Sleep(time.Millisecond)
+		}
+		So(volatile("1"), ShouldBeNil)
+		So(<-done, ShouldEqual, "41")
+		So(volatile("2"), ShouldEqual, NotWritableError)
+	})
+}
@@ -0,0 +1,166 @@
+package engineio
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SessionRegistry records which node owns each session. With a registry, a server getting a request
+// of a session it doesn't own forwards the request to the owner, so polling clients don't need a
+// sticky load balancer. A node is the base url of a server, as other servers reach it, like
+// "http://10.0.0.1:8000".
+type SessionRegistry interface {
+
+	// Register records node as the owner of session sid.
+	Register(sid, node string) error
+
+	// Lookup returns the owner of session sid, or "" if it's unknown.
+	Lookup(sid string) (string, error)
+
+	// Unregister removes session sid.
+	Unregister(sid string) error
+}
+
+// ExpiringRegistry is the SessionRegistry whose records expire, so the sessions of a node which dies
+// don't stay in it. The server registers its sessions for the ping interval plus the ping timeout, and
+// registers them again on every ping.
+type ExpiringRegistry interface {
+	SessionRegistry
+
+	// RegisterFor records node as the owner of session sid for ttl.
+	RegisterFor(sid, node string, ttl time.Duration) error
+}
+
+// register records the server as the owner of session sid, if it has a registry.
+func (s *Server) register(sid string) error {
+	if s.registry == nil {
+		return nil
+	}
+	if r, ok := s.registry.(ExpiringRegistry); ok {
+		return r.RegisterFor(sid, s.node, s.config.PingInterval+s.config.PingTimeout)
+	}
+	return s.registry.Register(sid, s.node)
+}
+
+// forwardedHeader marks a forwarded request, which isn't forwarded again.
+const forwardedHeader = "X-Engineio-Forwarded-By"
+
+// forward sends the request of session sid to its owner if the registry knows one. It returns false
+// if the request isn't forwarded.
+func (s *Server) forward(w http.ResponseWriter, r *http.Request, sid string) bool {
+	if s.registry == nil || r.Header.Get(forwardedHeader) != "" {
+		return false
+	}
+	node, err := s.registry.Lookup(sid)
+	if err != nil || node == "" || node == s.node {
+		return false
+	}
+	u, err := url.Parse(node)
+	if err != nil {
+		return false
+	}
+	r.Header.Set(forwardedHeader, s.node)
+	httputil.NewSingleHostReverseProxy(u).ServeHTTP(w, r)
+	return true
+}
+
+// MemoryRegistry is the SessionRegistry of servers in one process.
+type MemoryRegistry struct {
+	nodes  map[string]string
+	locker sync.RWMutex
+}
+
+// NewMemoryRegistry returns an empty MemoryRegistry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		nodes: make(map[string]string),
+	}
+}
+
+func (m *MemoryRegistry) Register(sid, node string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	m.nodes[sid] = node
+	return nil
+}
+
+func (m *MemoryRegistry) Lookup(sid string) (string, error) {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+
+	return m.nodes[sid], nil
+}
+
+func (m *MemoryRegistry) Unregister(sid string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	delete(m.nodes, sid)
+	return nil
+}
+
+// FileRegistry is the SessionRegistry in a directory shared by the servers, e.g. on a network file
+// system. Each session is a file holding the node.
+type FileRegistry struct {
+	dir string
+}
+
+// NewFileRegistry returns the FileRegistry in dir, which is created if it doesn't exist.
+func NewFileRegistry(dir string) (*FileRegistry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileRegistry{
+		dir: dir,
+	}, nil
+}
+
+// path returns the file of session sid. The sid comes from the client, so it's hex encoded to keep it
+// in the directory.
+func (f *FileRegistry) path(sid string) string {
+	return filepath.Join(f.dir, hex.EncodeToString([]byte(sid)))
+}
+
+func (f *FileRegistry) Register(sid, node string) error {
+	tmp, err := ioutil.TempFile(f.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write([]byte(node)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(sid))
+}
+
+func (f *FileRegistry) Lookup(sid string) (string, error) {
+	b, err := ioutil.ReadFile(f.path(sid))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (f *FileRegistry) Unregister(sid string) error {
+	err := os.Remove(f.path(sid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+package engineio
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+	Convey("File registry", t, func() {
+		dir, err := ioutil.TempDir("", "registry")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		registry, err := NewFileRegistry(filepath.Join(dir, "sessions"))
+		So(err, ShouldBeNil)
+
+		node, err := registry.Lookup("a")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "")
+
+		So(registry.Register("a", "http://node1"), ShouldBeNil)
+		node, err = registry.Lookup("a")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "http://node1")
+
+		So(registry.Register("../../a", "http://node2"), ShouldBeNil)
+		files, err := ioutil.ReadDir(filepath.Join(dir, "sessions"))
+		So(err, ShouldBeNil)
+		So(len(files), ShouldEqual, 2)
+
+		So(registry.Unregister("a"), ShouldBeNil)
+		So(registry.Unregister("a"), ShouldBeNil)
+		node, err = registry.Lookup("a")
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "")
+	})
+
+	Convey("Forward polling to the owner", t, func() {
+		registry := NewMemoryRegistry()
+		server1, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		h1 := httptest.NewServer(server1)
+		defer h1.Close()
+		server1.SetSessionRegistry(registry, h1.URL)
+		server2, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		h2 := httptest.NewServer(server2)
+		defer h2.Close()
+		server2.SetSessionRegistry(registry, h2.URL)
+
+		poll := func(url, method, query, body string) (int, string) {
+			req, err := http.NewRequest(method, url+"/?EIO=3&transport=polling&b64=1"+query, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return resp.StatusCode, string(b)
+		}
+
+		accept := make(chan Conn, 1)
+		go func() {
+			conn, _ := server1.Accept()
+			accept <- conn
+		}()
+		_, open := poll(h1.URL, "GET", "", "")
+		var info connectionInfo
+		So(json.Unmarshal([]byte(open[strings.Index(open, "{"):]), &info), ShouldBeNil)
+		node, err := registry.Lookup(info.Sid)
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, h1.URL)
+		conn := <-accept
+
+		received := make(chan string, 1)
+		go func() {
+			_, r, err := conn.NextReader()
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			b, _ := ioutil.ReadAll(r)
+			r.Close()
+			received <- string(b)
+		}()
+		code, body := poll(h2.URL, "POST", "&sid="+info.Sid, "6:4hello")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body, ShouldEqual, "ok")
+		So(<-received, ShouldEqual, "hello")
+
+		w, err := conn.NextWriter(MessageText)
+		So(err, ShouldBeNil)
+		w.Write([]byte("world"))
+		w.Close()
+		code, body = poll(h2.URL, "GET", "&sid="+info.Sid, "")
+		So(code, ShouldEqual, http.StatusOK)
+		So(body, ShouldEqual, "6:4world")
+
+		code, _ = poll(h2.URL, "GET", "&sid=unknown", "")
+		So(code, ShouldEqual, http.StatusBadRequest)
+
+		conn.Close()
+		poll(h1.URL, "GET", "&sid="+info.Sid, "")
+		node, err = registry.Lookup(info.Sid)
+		So(err, ShouldBeNil)
+		So(node, ShouldEqual, "")
+	})
+	Convey("Registry errors", t, func() {
+		registry := &testRegistry{MemoryRegistry: NewMemoryRegistry(), ttls: make(chan time.Duration, 10)}
+		logger := &testLogger{}
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		server.SetPingInterval(100 * time.Millisecond)
+		server.SetPingTimeout(time.Second)
+		server.SetLogger(logger)
+		h := httptest.NewServer(server)
+		defer h.Close()
+		server.SetSessionRegistry(registry, h.URL)
+		go func() {
+			for {
+				if _, err := server.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+		defer server.Close()
+
+		poll := func(method, query, body string) (int, string) {
+			req, err := http.NewRequest(method, h.URL+"/?EIO=3&transport=polling&b64=1"+query, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return resp.StatusCode, string(b)
+		}
+
+		Convey("reject the handshake when the session isn't registered", func() {
+			registry.setErr(errors.New("registry down"))
+			code, _ := poll("GET", "", "")
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(logger.last(), ShouldEqual, "session not registered")
+		})
+
+		Convey("register for the ping interval and timeout, again on every ping", func() {
+			code, open := poll("GET", "", "")
+			So(code, ShouldEqual, http.StatusOK)
+			var info connectionInfo
+			So(json.Unmarshal([]byte(open[strings.Index(open, "{"):]), &info), ShouldBeNil)
+			So(<-registry.ttls, ShouldEqual, 1100*time.Millisecond)
+
+			code, _ = poll("POST", "&sid="+info.Sid, "1:2")
+			So(code, ShouldEqual, http.StatusOK)
+			So(<-registry.ttls, ShouldEqual, 1100*time.Millisecond)
+
+			registry.setErr(errors.New("registry down"))
+			poll("POST", "&sid="+info.Sid, "1:1")
+			for logger.last() != "session not unregistered" {
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	})
+}
+
+// testRegistry is the ExpiringRegistry which reports the ttls, and fails once err is set.
+type testRegistry struct {
+	*MemoryRegistry
+	ttls chan time.Duration
+	err  error
+	lock sync.Mutex
+}
+
+func (r *testRegistry) setErr(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.err = err
+}
+
+func (r *testRegistry) getErr() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+func (r *testRegistry) RegisterFor(sid, node string, ttl time.Duration) error {
+	if err := r.getErr(); err != nil {
+		return err
+	}
+	r.ttls <- ttl
+	return r.Register(sid, node)
+}
+
+func (r *testRegistry) Unregister(sid string) error {
+	if err := r.getErr(); err != nil {
+		return err
+	}
+	return r.MemoryRegistry.Unregister(sid)
+}
+
+// testLogger keeps the message of the last record.
+type testLogger struct {
+	msg  string
+	lock sync.Mutex
+}
+
+func (l *testLogger) Error(msg string, keyvals ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.msg = msg
+}
+
+func (l *testLogger) last() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.msg
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/pschlump/socketio/engineio/polling"
+	"github.com/pschlump/socketio/engineio/transport"
 	"github.com/pschlump/socketio/engineio/websocket"
 )
 
@@ -21,6 +24,13 @@ type config struct {
 	AllowUpgrades bool
 	Cookie        string
 	NewId         func(r *http.Request) string
+	Metrics       Metrics
+	Logger        Logger
+	CORS          CORS
+
+	MaxHTTPBufferSize int64
+	QueueSize         int
+	QueuePolicy       QueuePolicy
 }
 
 // Server is the server of engine.io.
@@ -30,8 +40,18 @@ type Server struct {
 	serverSessions    Sessions
 	creaters          transportCreaters
 	currentConnection int32
+	registry          SessionRegistry
+	node              string
+	closeChan         chan struct{}
+	closeOnce         sync.Once
 }
 
+// ServerClosedError is returned by Accept after Close.
+var ServerClosedError = errors.New("server closed")
+
+// PayloadTooLargeError closes the connections of clients which send more than the max buffer size.
+var PayloadTooLargeError = transport.PayloadTooLargeError
+
 // NewServer returns the server suppported given transports. If transports is nil, server will use ["polling", "websocket"] as default.
 func NewServer(transports []string) (*Server, error) {
 	if transports == nil {
@@ -57,10 +77,17 @@ func NewServer(transports []string) (*Server, error) {
 			AllowUpgrades: true,
 			Cookie:        "io",
 			NewId:         newId,
+			Metrics:       nopMetrics{},
+			Logger:        nopLogger{},
+
+			MaxHTTPBufferSize: 1000000,
+			QueueSize:         10000,
+			QueuePolicy:       Disconnect,
 		},
 		socketChan:     make(chan Conn),
 		serverSessions: newServerSessions(),
 		creaters:       creaters,
+		closeChan:      make(chan struct{}),
 	}, nil
 }
 
@@ -99,46 +126,131 @@ func (s *Server) SetNewId(f func(*http.Request) string) {
 	s.config.NewId = f
 }
 
+// SetMetrics sets the receiver of the measurements of connections. Default drops them.
+func (s *Server) SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	s.config.Metrics = m
+}
+
+// SetLogger sets the logger of the errors of the server which no caller gets. Default logs nothing.
+func (s *Server) SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	s.config.Logger = l
+}
+
+// SetWebsocketOptions configures the websocket transport, e.g. the origins which may connect. It does
+// nothing if the server has no websocket transport.
+func (s *Server) SetWebsocketOptions(opts websocket.Options) {
+	if _, ok := s.creaters["websocket"]; ok {
+		s.creaters["websocket"] = websocket.NewCreater(opts)
+	}
+}
+
+// SetMaxHTTPBufferSize sets the max bytes a client may send in one polling request or websocket
+// message, 0 for no limit. The connection of a client which sends more is closed. Default is 1e6.
+func (s *Server) SetMaxHTTPBufferSize(n int64) {
+	s.config.MaxHTTPBufferSize = n
+}
+
+// SetOutboundQueue sets the max messages queued for a client which reads them slower than they are
+// sent, 0 for no limit, and what is done when a message is sent to a full queue. Default is 10000
+// messages and Disconnect.
+func (s *Server) SetOutboundQueue(size int, policy QueuePolicy) {
+	s.config.QueueSize = size
+	s.config.QueuePolicy = policy
+}
+
+// SetCORS sets the origins which browsers may send long-polling requests from, and answers their
+// preflight requests. Default allows no other origin.
+func (s *Server) SetCORS(cors CORS) {
+	s.config.CORS = cors
+}
+
 // SetSessionManager sets the sessions as server's session manager. Default sessions is single process manager. You can custom it as load balance.
 func (s *Server) SetSessionManager(sessions Sessions) {
 	s.serverSessions = sessions
 }
 
+// SetSessionRegistry sets the registry of session owners, and node as the base url of this server, e.g.
+// "http://10.0.0.1:8000". Requests of sessions owned by other nodes are forwarded to them. Default is
+// no registry, the sessions of other nodes are invalid.
+func (s *Server) SetSessionRegistry(registry SessionRegistry, node string) {
+	s.registry = registry
+	s.node = node
+}
+
 // ServeHTTP handles http request.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	sid := r.URL.Query().Get("sid")
 	conn := s.serverSessions.Get(sid)
+	// The owner of the session answers with its own CORS headers.
+	if conn == nil && sid != "" && r.Method != "OPTIONS" && s.forward(w, r, sid) {
+		return
+	}
+	if s.config.CORS.apply(w, r) {
+		return
+	}
 	if conn == nil {
 		if sid != "" {
 			http.Error(w, "invalid sid", http.StatusBadRequest)
 			return
 		}
 
+		if s.isClosed() {
+			s.config.Metrics.HandshakeRejected("server_closed")
+			http.Error(w, "server closed", http.StatusServiceUnavailable)
+			return
+		}
+
 		if err := s.config.AllowRequest(r); err != nil {
+			s.config.Metrics.HandshakeRejected("allow_request")
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		n := atomic.AddInt32(&s.currentConnection, 1)
 		if int(n) > s.config.MaxConnection {
+			atomic.AddInt32(&s.currentConnection, -1)
+			s.config.Metrics.HandshakeRejected("max_connection")
 			http.Error(w, "too many connections", http.StatusServiceUnavailable)
 			return
 		}
 
 		sid = s.config.NewId(r)
+		if err := s.register(sid); err != nil {
+			atomic.AddInt32(&s.currentConnection, -1)
+			s.config.Metrics.HandshakeRejected("registry")
+			s.config.Logger.Error("session not registered", "sid", sid, "err", err)
+			http.Error(w, "session not registered", http.StatusServiceUnavailable)
+			return
+		}
 
 		var err error
 		conn, err = newServerConn(sid, w, r, s)
 		if err != nil {
+			atomic.AddInt32(&s.currentConnection, -1)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		s.serverSessions.Set(sid, conn)
 
-		s.socketChan <- conn
+		select {
+		case s.socketChan <- conn:
+		case <-s.closeChan:
+			conn.Close()
+			// websocket has taken over the connection already
+			if r.URL.Query().Get("transport") == "polling" {
+				http.Error(w, "server closed", http.StatusServiceUnavailable)
+			}
+			return
+		}
 	}
 	http.SetCookie(w, &http.Cookie{
 		Name:  s.config.Cookie,
@@ -148,9 +260,32 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	conn.(*serverConn).ServeHTTP(w, r)
 }
 
-// Accept returns Conn when client connect to server.
+// Accept returns Conn when client connect to server. It returns ServerClosedError after Close.
 func (s *Server) Accept() (Conn, error) {
-	return <-s.socketChan, nil
+	select {
+	case conn := <-s.socketChan:
+		return conn, nil
+	case <-s.closeChan:
+		return nil, ServerClosedError
+	}
+}
+
+// Close stops accepting new connections, handshakes get 503 and Accept returns ServerClosedError.
+// The connections already accepted are left open.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	select {
+	case <-s.closeChan:
+		return true
+	default:
+		return false
+	}
 }
 
 func (s *Server) configure() config {
@@ -163,9 +298,20 @@ func (s *Server) transports() transportCreaters {
 
 func (s *Server) onClose(id string) {
 	s.serverSessions.Remove(id)
+	if s.registry != nil {
+		if err := s.registry.Unregister(id); err != nil {
+			s.config.Logger.Error("session not unregistered", "sid", id, "err", err)
+		}
+	}
 	atomic.AddInt32(&s.currentConnection, -1)
 }
 
+func (s *Server) onPing(id string) {
+	if err := s.register(id); err != nil {
+		s.config.Logger.Error("session not registered again", "sid", id, "err", err)
+	}
+}
+
 func newId(r *http.Request) string {
 	hash := fmt.Sprintf("%s %s", r.RemoteAddr, time.Now())
 	buf := bytes.NewBuffer(nil)
@@ -50,6 +50,7 @@ type serverCallback interface {
 	configure() config
 	transports() transportCreaters
 	onClose(sid string)
+	onPing(sid string)
 }
 
 type state int
@@ -78,10 +79,23 @@ type serverConn struct {
 	pingTimeout     time.Duration
 	pingInterval    time.Duration
 	pingChan        chan bool
+	metrics         Metrics
+	queue           *sendQueue
+	closeChan       chan struct{}
 }
 
 var InvalidError = errors.New("invalid transport")
 
+// connectionInfo is the handshake sent in the OPEN packet. PingInterval and PingTimeout are in milliseconds.
+// MaxPayload, the max bytes of a payload the client may send in one request, is only sent to engine.io v4 clients.
+type connectionInfo struct {
+	Sid          string        `json:"sid"`
+	Upgrades     []string      `json:"upgrades"`
+	PingInterval time.Duration `json:"pingInterval"`
+	PingTimeout  time.Duration `json:"pingTimeout"`
+	MaxPayload   int64         `json:"maxPayload,omitempty"`
+}
+
 func newServerConn(id string, w http.ResponseWriter, r *http.Request, callback serverCallback) (*serverConn, error) {
 	transportName := r.URL.Query().Get("transport")
 	creater := callback.transports().Get(transportName)
@@ -97,6 +111,12 @@ func newServerConn(id string, w http.ResponseWriter, r *http.Request, callback s
 		pingTimeout:  callback.configure().PingTimeout,
 		pingInterval: callback.configure().PingInterval,
 		pingChan:     make(chan bool),
+		metrics:      callback.configure().Metrics,
+		queue:        newSendQueue(callback.configure().QueueSize, callback.configure().QueuePolicy),
+		closeChan:    make(chan struct{}),
+	}
+	if ret.metrics == nil {
+		ret.metrics = nopMetrics{}
 	}
 	transport, err := creater.Server(w, r, ret)
 	if err != nil {
@@ -106,8 +126,10 @@ func newServerConn(id string, w http.ResponseWriter, r *http.Request, callback s
 	if err := ret.onOpen(); err != nil {
 		return nil, err
 	}
+	ret.metrics.ConnectionOpened(transportName)
 
 	go ret.pingLoop()
+	go ret.writeLoop()
 
 	return ret, nil
 }
@@ -116,6 +138,16 @@ func (c *serverConn) Id() string {
 	return c.id
 }
 
+// Transport returns the name of the current transport, like "polling".
+func (c *serverConn) Transport() string {
+	return c.getCurrentName()
+}
+
+// MaxHTTPBufferSize returns the max bytes the client may send in one request or message.
+func (c *serverConn) MaxHTTPBufferSize() int64 {
+	return c.callback.configure().MaxHTTPBufferSize
+}
+
 func (c *serverConn) Request() *http.Request {
 	return c.request
 }
@@ -131,24 +163,25 @@ func (c *serverConn) NextReader() (MessageType, io.ReadCloser, error) {
 	return MessageType(ret.MessageType()), ret, nil
 }
 
+// NextWriter returns the writer of a message, which is queued when the writer is closed. The
+// messages are written to the transport in the background, once it is done upgrading.
 func (c *serverConn) NextWriter(t MessageType) (io.WriteCloser, error) {
 	switch c.getState() {
-	case stateUpgrading:
-		for i := 0; i < 30; i++ {
-			time.Sleep(50 * time.Millisecond)
-			if c.getState() != stateUpgrading {
-				break
-			}
-		}
-		if c.getState() == stateUpgrading {
-			return nil, fmt.Errorf("upgrading")
-		}
-	case stateNormal:
+	case stateNormal, stateUpgrading:
 	default:
 		return nil, io.EOF
 	}
-	ret, err := c.getCurrent().NextWriter(message.MessageType(t), parser.MESSAGE)
-	return ret, err
+	return &queueWriter{typ: t, conn: c}, nil
+}
+
+// NextVolatileWriter returns the writer of a message which is dropped unless it reaches the client at
+// once: the connection isn't upgrading, nothing is queued or being written, and a polling client waits
+// for messages. Close returns NotWritableError if the message is dropped.
+func (c *serverConn) NextVolatileWriter(t MessageType) (io.WriteCloser, error) {
+	if c.getState() != stateNormal {
+		return nil, NotWritableError
+	}
+	return &queueWriter{typ: t, conn: c, volatile: true}, nil
 }
 
 func (c *serverConn) Close() error {
@@ -158,6 +191,7 @@ func (c *serverConn) Close() error {
 	if c.upgrading != nil {
 		c.upgrading.Close()
 	}
+	c.flush()
 	c.writerLocker.Lock()
 	if w, err := c.getCurrent().NextWriter(message.MessageText, parser.CLOSE); err == nil {
 		writer := newConnWriter(w, &c.writerLocker)
@@ -231,7 +265,9 @@ func (c *serverConn) OnPacket(r *parser.PacketDecoder) {
 func (c *serverConn) OnClose(server transport.Server) {
 	if t := c.getUpgrade(); server == t {
 		c.setUpgrading("", nil)
+		c.setState(stateNormal)
 		t.Close()
+		c.queue.wake()
 		return
 	}
 	t := c.getCurrent()
@@ -246,6 +282,8 @@ func (c *serverConn) OnClose(server transport.Server) {
 	c.setState(stateClosed)
 	close(c.readerChan)
 	close(c.pingChan)
+	close(c.closeChan)
+	c.metrics.ConnectionClosed(c.getCurrentName())
 	c.callback.onClose(c.id)
 }
 
@@ -257,18 +295,15 @@ func (s *serverConn) onOpen() error {
 		}
 		upgrades = append(upgrades, name)
 	}
-	type connectionInfo struct {
-		Sid          string        `json:"sid"`
-		Upgrades     []string      `json:"upgrades"`
-		PingInterval time.Duration `json:"pingInterval"`
-		PingTimeout  time.Duration `json:"pingTimeout"`
-	}
 	resp := connectionInfo{
 		Sid:          s.Id(),
 		Upgrades:     upgrades,
 		PingInterval: s.callback.configure().PingInterval / time.Millisecond,
 		PingTimeout:  s.callback.configure().PingTimeout / time.Millisecond,
 	}
+	if s.request.URL.Query().Get("EIO") == "4" {
+		resp.MaxPayload = s.MaxHTTPBufferSize()
+	}
 	w, err := s.getCurrent().NextWriter(message.MessageText, parser.OPEN)
 	if err != nil {
 		return err
@@ -290,6 +325,13 @@ func (c *serverConn) getCurrent() transport.Server {
 	return c.current
 }
 
+func (c *serverConn) getCurrentName() string {
+	c.transportLocker.RLock()
+	defer c.transportLocker.RUnlock()
+
+	return c.currentName
+}
+
 func (c *serverConn) getUpgrade() transport.Server {
 	c.transportLocker.RLock()
 	defer c.transportLocker.RUnlock()
@@ -315,18 +357,107 @@ func (c *serverConn) setUpgrading(name string, s transport.Server) {
 }
 
 func (c *serverConn) upgraded() {
+	c.writerLocker.Lock()
+	defer c.writerLocker.Unlock()
 	c.transportLocker.Lock()
 
-	current := c.current
+	current, from := c.current, c.currentName
 	c.current = c.upgrading
 	c.currentName = c.upgradingName
 	c.upgrading = nil
 	c.upgradingName = ""
+	to := c.currentName
 
 	c.transportLocker.Unlock()
 
+	c.metrics.ConnectionUpgraded(from, to)
+	if f, ok := current.(flusher); ok {
+		f.FlushTo(c.getCurrent())
+	}
 	current.Close()
 	c.setState(stateNormal)
+	c.queue.wake()
+}
+
+// OnDrain is called when the transport has handed the messages it held to the client.
+func (c *serverConn) OnDrain() {
+	c.queue.wake()
+}
+
+// writeLoop writes the queued messages to the transport until the connection is closed.
+func (c *serverConn) writeLoop() {
+	for {
+		select {
+		case <-c.queue.notify:
+			if !c.queue.empty() {
+				c.flush()
+			}
+		case <-c.closeChan:
+			return
+		}
+	}
+}
+
+// flush writes the queued messages to the current transport, unless it is upgrading or still holds
+// messages the client hasn't taken.
+func (c *serverConn) flush() {
+	c.writerLocker.Lock()
+	defer c.writerLocker.Unlock()
+	if c.getState() != stateNormal {
+		return
+	}
+	t := c.getCurrent()
+	if h, ok := t.(transport.Holder); ok && h.Holding() {
+		return
+	}
+	taken := c.queue.take()
+	for i, m := range taken {
+		w, err := t.NextWriter(message.MessageType(m.typ), parser.MESSAGE)
+		if err != nil {
+			// Nothing of m is written, keep it and the rest for the next flush.
+			c.queue.pushFront(taken[i:])
+			return
+		}
+		_, err = w.Write(m.data)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// m may be written in part, so the client can't be sent the rest in order.
+			go t.Close()
+			return
+		}
+	}
+}
+
+// writeVolatile writes m to the transport if it reaches the client at once, or returns NotWritableError.
+// The check and the write are done under the writer lock, so no other write or upgrade comes between.
+func (c *serverConn) writeVolatile(m queuedMessage) error {
+	if !c.writerLocker.TryLock() {
+		return NotWritableError
+	}
+	defer c.writerLocker.Unlock()
+	if c.getState() != stateNormal || !c.queue.empty() {
+		return NotWritableError
+	}
+	t := c.getCurrent()
+	if h, ok := t.(transport.Holder); ok && (!h.Waiting() || h.Holding()) {
+		return NotWritableError
+	}
+	w, err := t.NextWriter(message.MessageType(m.typ), parser.MESSAGE)
+	if err != nil {
+		return NotWritableError
+	}
+	if _, err := w.Write(m.data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// flusher is the transport which buffers messages until the client asks for them, like polling.
+type flusher interface {
+	FlushTo(t transport.Server) error
 }
 
 func (c *serverConn) getState() state {
@@ -353,6 +484,7 @@ func (c *serverConn) pingLoop() {
 			if !ok {
 				return
 			}
+			c.callback.onPing(c.id)
 			lastPing = time.Now()
 			lastTry = lastPing
 		case <-time.After(c.pingInterval - tryDiff):
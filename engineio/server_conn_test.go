@@ -29,6 +29,8 @@ func newFakeServer() *FakeServer {
 			PingTimeout:   time.Second * 2,
 			PingInterval:  time.Second * 1,
 			AllowUpgrades: true,
+
+			MaxHTTPBufferSize: 1000000,
 		},
 		creaters: transportCreaters{
 			"polling":   polling.Creater,
@@ -52,6 +54,8 @@ func (f *FakeServer) onClose(sid string) {
 	f.closed[sid] = f.closed[sid] + 1
 }
 
+func (f *FakeServer) onPing(sid string) {}
+
 func TestConn(t *testing.T) {
 	Convey("Create conn", t, func() {
 		Convey("without transport", func() {
@@ -85,6 +89,22 @@ func TestConn(t *testing.T) {
 				conn.Close()
 			})
 
+			Convey("with polling v4", func() {
+				server := newFakeServer()
+				req, err := http.NewRequest("GET", "/?transport=polling&EIO=4", nil)
+				So(err, ShouldBeNil)
+				resp := httptest.NewRecorder()
+				conn, err := newServerConn("id", resp, req, server)
+				So(err, ShouldBeNil)
+				defer conn.Close()
+
+				resp = httptest.NewRecorder()
+				conn.ServeHTTP(resp, req)
+				So(resp.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+				So(resp.Body.String(), ShouldStartWith, `0{"sid":"id"`)
+				So(resp.Body.String(), ShouldContainSubstring, `"maxPayload":1000000`)
+			})
+
 			Convey("with websocket", func() {
 				server := newFakeServer()
 				h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1,7 +1,9 @@
 package engineio
 
 import (
+	"errors"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -36,4 +38,88 @@ func TestServer(t *testing.T) {
 		})
 
 	})
+
+	Convey("Close server", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		So(server.Close(), ShouldBeNil)
+		So(server.Close(), ShouldBeNil)
+
+		conn, err := server.Accept()
+		So(conn, ShouldBeNil)
+		So(err, ShouldEqual, ServerClosedError)
+
+		req := httptest.NewRequest("GET", "/?transport=polling", nil)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+	})
+
+	Convey("Reject handshakes", t, func() {
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		metrics := &rejectMetrics{}
+		server.SetMetrics(metrics)
+		server.SetMaxConnection(0)
+
+		for i := 0; i < 2; i++ {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, httptest.NewRequest("GET", "/?transport=polling", nil))
+			So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+		}
+		So(metrics.rejected, ShouldResemble, []string{"max_connection", "max_connection"})
+		So(server.currentConnection, ShouldEqual, 0)
+
+		server.SetAllowRequest(func(*http.Request) error { return errors.New("denied") })
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest("GET", "/?transport=polling", nil))
+		So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		So(metrics.rejected[2], ShouldEqual, "allow_request")
+	})
+
+	Convey("Answer cross-origin requests", t, func() {
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		server.SetCORS(CORS{
+			AllowedOrigins:   []string{"https://*.example.com"},
+			AllowCredentials: true,
+			AllowedHeaders:   []string{"Authorization"},
+			MaxAge:           time.Hour,
+		})
+		request := func(method, origin string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/?EIO=4&transport=polling", nil)
+			req.Header.Set("Origin", origin)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			return resp
+		}
+
+		resp := request("OPTIONS", "https://app.example.com")
+		So(resp.Code, ShouldEqual, http.StatusNoContent)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+		So(resp.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+		So(resp.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, POST, OPTIONS")
+		So(resp.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Authorization")
+		So(resp.Header().Get("Access-Control-Max-Age"), ShouldEqual, "3600")
+
+		resp = request("OPTIONS", "https://evil.io")
+		So(resp.Code, ShouldEqual, http.StatusForbidden)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "")
+
+		server.Close()
+		resp = request("GET", "https://app.example.com")
+		So(resp.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+		So(resp.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "")
+	})
+}
+
+// rejectMetrics keeps the reasons of rejected handshakes.
+type rejectMetrics struct {
+	nopMetrics
+	rejected []string
+}
+
+func (m *rejectMetrics) HandshakeRejected(reason string) {
+	m.rejected = append(m.rejected, reason)
 }
@@ -1,8 +1,10 @@
 package transport
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/pschlump/socketio/engineio/message"
 	"github.com/pschlump/socketio/engineio/parser"
@@ -33,6 +35,19 @@ type Server interface {
 	NextWriter(messageType message.MessageType, packetType parser.PacketType) (io.WriteCloser, error)
 }
 
+// Holder is the Server which holds written packets until the client asks for them, like polling.
+type Holder interface {
+	// Holding returns true while written packets wait for the client.
+	Holding() bool
+	// Waiting returns true while the client waits for packets, so they reach it at once.
+	Waiting() bool
+}
+
+// DrainCallback is the Callback which is told when a Holder has handed its packets to the client.
+type DrainCallback interface {
+	OnDrain()
+}
+
 // Client is a transport layer in client to connect server.
 type Client interface {
 
@@ -48,3 +63,78 @@ type Client interface {
 	// Close closes the transport.
 	Close() error
 }
+
+// PayloadTooLargeError is returned when a client sends more than the max buffer size at once.
+var PayloadTooLargeError = errors.New("payload too large")
+
+// BufferLimiter is the Callback which limits the bytes a client sends in one request or message.
+type BufferLimiter interface {
+	MaxHTTPBufferSize() int64
+}
+
+// MaxBufferSize returns the max bytes of a request or message to callback, 0 for no limit.
+func MaxBufferSize(callback Callback) int64 {
+	if l, ok := callback.(BufferLimiter); ok {
+		return l.MaxHTTPBufferSize()
+	}
+	return 0
+}
+
+// LimitReader returns a reader of r which fails with PayloadTooLargeError after n bytes.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitReader{r: r, n: n}
+}
+
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, PayloadTooLargeError
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n, l.n = int(l.n), -1
+		return n, PayloadTooLargeError
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// MatchOrigin returns true if origin, like "https://app.example.com", matches one of patterns. A "*"
+// in a pattern matches any part of the origin, e.g. "https://*.example.com".
+func MatchOrigin(patterns []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, p := range patterns {
+		if matchPattern(strings.ToLower(p), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches s against pattern, where "*" matches any run of characters.
+func matchPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
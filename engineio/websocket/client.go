@@ -12,6 +12,7 @@ import (
 )
 
 type client struct {
+	v4   bool
 	conn *websocket.Conn
 	resp *http.Response
 }
@@ -25,6 +26,7 @@ func NewClient(r *http.Request) (transport.Client, error) {
 	}
 
 	return &client{
+		v4:   r.URL.Query().Get("EIO") == "4",
 		conn: conn,
 		resp: resp,
 	}, nil
@@ -43,9 +45,13 @@ func (c *client) NextReader() (*parser.PacketDecoder, error) {
 		}
 		switch t {
 		case websocket.TextMessage:
-			fallthrough
+			reader = r
+			return parser.NewDecoder(reader)
 		case websocket.BinaryMessage:
 			reader = r
+			if c.v4 {
+				return parser.NewV4BinaryDecoder(reader)
+			}
 			return parser.NewDecoder(reader)
 		}
 	}
@@ -55,6 +61,9 @@ func (c *client) NextWriter(msgType message.MessageType, packetType parser.Packe
 	wsType, newEncoder := websocket.TextMessage, parser.NewStringEncoder
 	if msgType == message.MessageBinary {
 		wsType, newEncoder = websocket.BinaryMessage, parser.NewBinaryEncoder
+		if c.v4 {
+			newEncoder = parser.NewV4BinaryEncoder
+		}
 	}
 
 	w, err := c.conn.NextWriter(wsType)
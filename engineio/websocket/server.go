@@ -14,33 +14,93 @@ import (
 type Server struct {
 	callback transport.Callback
 	conn     *websocket.Conn
+	v4       bool
 }
 
-/*
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool { // Origin - PJS - Check
-		return true
-		// allow all connections by default - to do a test - a public site will need to have a "check-origin" policy
-		// that is based on users inputing the site that they will be using - that will get "pushed" and that will be valid for
-		// the origin.
-		//
-		// See data/go-chat-model.sql
-		//
-	},
+// Options configures the websocket transport of a server.
+type Options struct {
+	// AllowedOrigins are the origins which may connect, like "https://app.example.com". A "*"
+	// matches any part of an origin, e.g. "https://*.example.com". Empty allows every origin.
+	// Requests without an Origin header, which don't come from browsers, are always allowed.
+	AllowedOrigins []string
+
+	// CheckOrigin decides which requests may connect instead of AllowedOrigins.
+	CheckOrigin func(r *http.Request) bool
+
+	ReadBufferSize    int   // ReadBufferSize is the size of the read buffer in bytes. Default is 10240.
+	WriteBufferSize   int   // WriteBufferSize is the size of the write buffer in bytes. Default is 10240.
+	EnableCompression bool  // EnableCompression negotiates per-message deflate with clients which offer it.
+	MaxMessageSize    int64 // MaxMessageSize is the max bytes of a message from the client, if less than the max buffer size of the server.
+
+	// Subprotocols are the protocols the server supports, in order of preference.
+	Subprotocols []string
+}
+
+// NewCreater returns the websocket transport configured by opts.
+func NewCreater(opts Options) transport.Creater {
+	u := opts.upgrader()
+	return transport.Creater{
+		Name:      Creater.Name,
+		Upgrading: Creater.Upgrading,
+		Server: func(w http.ResponseWriter, r *http.Request, callback transport.Callback) (transport.Server, error) {
+			return newServer(w, r, callback, u, opts.MaxMessageSize)
+		},
+		Client: Creater.Client,
+	}
+}
+
+func (o Options) upgrader() *websocket.Upgrader {
+	u := &websocket.Upgrader{
+		ReadBufferSize:    o.ReadBufferSize,
+		WriteBufferSize:   o.WriteBufferSize,
+		EnableCompression: o.EnableCompression,
+		Subprotocols:      o.Subprotocols,
+		CheckOrigin:       o.CheckOrigin,
+		// The caller answers the failed request.
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
+	}
+	if u.ReadBufferSize == 0 {
+		u.ReadBufferSize = 10240
+	}
+	if u.WriteBufferSize == 0 {
+		u.WriteBufferSize = 10240
+	}
+	if u.CheckOrigin == nil {
+		origins := o.AllowedOrigins
+		u.CheckOrigin = func(r *http.Request) bool {
+			return allowOrigin(origins, r.Header.Get("Origin"))
+		}
+	}
+	return u
+}
+
+// allowOrigin returns true if origin matches one of patterns, or if either is empty.
+func allowOrigin(patterns []string, origin string) bool {
+	return len(patterns) == 0 || origin == "" || transport.MatchOrigin(patterns, origin)
 }
-*/
+
+var defaultUpgrader = Options{}.upgrader()
 
 func NewServer(w http.ResponseWriter, r *http.Request, callback transport.Callback) (transport.Server, error) {
-	conn, err := websocket.Upgrade(w, r, nil, 10240, 10240) // Origin is NIL parameter?? PJS
+	return newServer(w, r, callback, defaultUpgrader, 0)
+}
+
+func newServer(w http.ResponseWriter, r *http.Request, callback transport.Callback, u *websocket.Upgrader, maxMessageSize int64) (transport.Server, error) {
+	conn, err := u.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, err
 	}
+	if n := transport.MaxBufferSize(callback); n > 0 && (maxMessageSize <= 0 || n < maxMessageSize) {
+		maxMessageSize = n
+	}
+	if maxMessageSize > 0 {
+		conn.SetReadLimit(maxMessageSize)
+	}
 
 	ret := &Server{
 		callback: callback,
 		conn:     conn,
+		v4:       r.URL.Query().Get("EIO") == "4",
 	}
 
 	go ret.serveHTTP(w, r)
@@ -56,6 +116,9 @@ func (s *Server) NextWriter(msgType message.MessageType, packetType parser.Packe
 	wsType, newEncoder := websocket.TextMessage, parser.NewStringEncoder
 	if msgType == message.MessageBinary {
 		wsType, newEncoder = websocket.BinaryMessage, parser.NewBinaryEncoder
+		if s.v4 {
+			newEncoder = parser.NewV4BinaryEncoder
+		}
 	}
 
 	w, err := s.conn.NextWriter(wsType)
@@ -87,7 +150,11 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		case websocket.TextMessage:
 			fallthrough
 		case websocket.BinaryMessage:
-			decoder, err := parser.NewDecoder(r)
+			newDecoder := parser.NewDecoder
+			if s.v4 && t == websocket.BinaryMessage {
+				newDecoder = parser.NewV4BinaryDecoder
+			}
+			decoder, err := newDecoder(r)
 			if err != nil {
 				return
 			}
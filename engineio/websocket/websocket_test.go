@@ -312,6 +312,48 @@ func TestWebsocket(t *testing.T) {
 		sync <- 1
 	})
 
+	Convey("Options", t, func() {
+		So(allowOrigin([]string{"https://*.example.com"}, "https://App.example.com"), ShouldBeTrue)
+		So(allowOrigin([]string{"https://*.example.com"}, "https://example.com"), ShouldBeFalse)
+		So(allowOrigin([]string{"https://*.example.com"}, "https://app.example.com.evil.io"), ShouldBeFalse)
+		So(allowOrigin([]string{"http://localhost:*"}, "http://localhost:3000"), ShouldBeTrue)
+		So(allowOrigin([]string{"https://example.com"}, ""), ShouldBeTrue)
+		So(allowOrigin(nil, "https://any.io"), ShouldBeTrue)
+
+		creater := NewCreater(Options{
+			AllowedOrigins: []string{"https://*.example.com"},
+			MaxMessageSize: 16,
+			Subprotocols:   []string{"engine.io"},
+		})
+		f := newFakeCallback()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := creater.Server(w, r, f); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			}
+		}))
+		defer server.Close()
+
+		u, err := url.Parse(server.URL)
+		So(err, ShouldBeNil)
+		u.Scheme = "ws"
+		dial := func(origin string) (*websocket.Conn, *http.Response, error) {
+			header := http.Header{"Origin": {origin}, "Sec-Websocket-Protocol": {"engine.io"}}
+			return websocket.DefaultDialer.Dial(u.String(), header)
+		}
+
+		_, resp, err := dial("https://evil.io")
+		So(err, ShouldNotBeNil)
+		So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+
+		c, resp, err := dial("https://app.example.com")
+		So(err, ShouldBeNil)
+		defer c.Close()
+		So(resp.Header.Get("Sec-Websocket-Protocol"), ShouldEqual, "engine.io")
+		So(c.WriteMessage(websocket.TextMessage, []byte("4 message over the limit")), ShouldBeNil)
+		_, _, err = c.ReadMessage()
+		So(websocket.IsCloseError(err, websocket.CloseMessageTooBig), ShouldBeTrue)
+	})
+
 	Convey("Close", t, func() {
 		f := newFakeCallback()
 		var s transport.Server
@@ -18,6 +18,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
@@ -74,9 +75,6 @@ func main() {
 		for _, s := range strings.Split(*Debug, ",") {
 			DebugFlag[s] = true
 		}
-		if DebugFlag["socketio.Db1"] {
-			socketio.Db1 = true
-		}
 	}
 
 	// Make certain that the command line parameters are handled correctly
@@ -86,6 +84,9 @@ func main() {
 	if err != nil {
 		log.Fatal(fmt.Errorf("During attempt to create a new Socket.IO Server: %s, AT:%s", err, godebug.LF()))
 	}
+	if DebugFlag["socketio"] {
+		server.SetLogger(socketio.NewSlogLogger(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
 
 	// connection ->
 	//	new messsage -> brodcast "chat message"
@@ -1,22 +1,30 @@
 package socketio
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
-	"github.com/pschlump/godebug"
-	logrus "github.com/pschlump/pslog" // "github.com/sirupsen/logrus"
+	"github.com/pschlump/json" //	"encoding/json"
 )
 
+// EventHandlerFunc handles message without reflection. args holds the raw JSON of each arg, followed by
+// the binary attachments in the order of their placeholder num. A non-nil error closes the socket.
+//
 // PJS - could have it return more than just an error, if "rmsg" and "rbody" - then emit response?
 // that makes it more like a RPC - call a func get back a response
-type EventHandlerFunc func(so *Socket, message string, args [][]byte) error
+type EventHandlerFunc func(so Socket, message string, args [][]byte) error
+
+// ackHandlerFunc is the raw handler which gets the raw JSON of the args apart from the attachments, and
+// returns the args of the ack, see OnEvent.
+type ackHandlerFunc func(so Socket, message string, raw []json.RawMessage, binary [][]byte) ([]interface{}, error)
 
 type baseHandler struct {
 	events      map[string]*caller
 	allEvents   []*caller
-	x_events    map[string]EventHandlerFunc
+	x_events    map[string]ackHandlerFunc
 	x_allEvents []EventHandlerFunc
 	name        string
 	broadcast   BroadcastAdaptor
@@ -26,10 +34,12 @@ type baseHandler struct {
 func newBaseHandler(name string, broadcast BroadcastAdaptor) *baseHandler {
 	// fmt.Printf("*********************************************************************** this one *********************************************************************\n")
 	return &baseHandler{
-		events:    make(map[string]*caller),
-		allEvents: make([]*caller, 0, 5),
-		name:      name,
-		broadcast: broadcast,
+		events:      make(map[string]*caller),
+		allEvents:   make([]*caller, 0, 5),
+		x_events:    make(map[string]ackHandlerFunc),
+		x_allEvents: make([]EventHandlerFunc, 0, 5),
+		name:        name,
+		broadcast:   broadcast,
 	}
 }
 
@@ -45,13 +55,23 @@ func (h *baseHandler) On(message string, f interface{}) error {
 	return nil
 }
 
+// Handle registers the raw handler f to handle message. It is used instead of the func registered by On.
 func (h *baseHandler) Handle(message string, f EventHandlerFunc) error {
+	return h.handleAck(message, func(so Socket, message string, raw []json.RawMessage, binary [][]byte) ([]interface{}, error) {
+		return nil, f(so, message, rawArgs(raw, binary))
+	})
+}
+
+// handleAck registers the raw handler f to handle message, like Handle, and sends back what it returns
+// if the client asks for an ack.
+func (h *baseHandler) handleAck(message string, f ackHandlerFunc) error {
 	h.lock.Lock()
 	h.x_events[message] = f
 	h.lock.Unlock()
 	return nil
 }
 
+// HandleAny registers the raw handler f to handle every message, before the handler of the message.
 func (h *baseHandler) HandleAny(f EventHandlerFunc) error {
 	h.lock.Lock()
 	h.x_allEvents = append(h.x_allEvents, f)
@@ -59,45 +79,70 @@ func (h *baseHandler) HandleAny(f EventHandlerFunc) error {
 	return nil
 }
 
-// On registers the function f to handle ANY message.
+// OnAny registers the function f to handle ANY message, before the handler of the message or instead
+// of it if there is none. f takes the message name after the optional Socket, then the args like the
+// func of On, or a single []interface{} to get all args. If the message has no handler, the values
+// returned by the last f which returns any are sent back as ack.
 func (h *baseHandler) OnAny(f interface{}) error {
 	c, err := newCaller(f)
 	if err != nil {
 		return err
 	}
+	if len(c.Args) == 0 || c.Args[0].Kind() != reflect.String {
+		return &HandlerError{Func: c.Func.Type(), Reason: "the message name must be the first arg"}
+	}
 	h.lock.Lock()
 	h.allEvents = append(h.allEvents, c)
 	h.lock.Unlock()
 	return nil
 }
 
-func (h *baseHandler) PrintEventsRespondedTo() {
-	fmt.Printf("\tEvents:[")
-	com := ""
-	for i := range h.events {
-		fmt.Printf("%s%s", com, i)
-		com = ", "
-	}
-	fmt.Printf(" ] AllEvents = %d", len(h.allEvents))
-	fmt.Printf("\n")
-}
-
 type socketHandler struct {
 	*baseHandler
-	acks   map[int]*caller
+	acks   map[int]*ack
 	socket *socket
 	rooms  map[string]struct{}
 }
 
+// ack is a pending acknowledgement of an emit, which is either handed to the func c or, for
+// EmitWithAck, sent to raw.
+type ack struct {
+	c     *caller
+	raw   chan ackResult
+	timer *time.Timer
+	sent  time.Time
+}
+
+type ackResult struct {
+	args []json.RawMessage
+	err  error
+}
+
+// fail tells the waiter of a that the ack won't come. A func without an error arg isn't called.
+func (a *ack) fail(so Socket, err error) {
+	if a.raw != nil {
+		a.raw <- ackResult{err: err}
+		return
+	}
+	if a.c.NeedError {
+		a.c.CallError(so, err, make([]interface{}, len(a.c.Args)))
+	}
+}
+
 func newSocketHandler(s *socket, base *baseHandler) *socketHandler {
 	events := make(map[string]*caller)
 	allEvents := make([]*caller, 0, 5)
-	x_events := make(map[string]EventHandlerFunc)
+	x_events := make(map[string]ackHandlerFunc)
 	x_allEvents := make([]EventHandlerFunc, 0, 5)
 	base.lock.Lock()
 	for k, v := range base.events {
 		events[k] = v
 	}
+	allEvents = append(allEvents, base.allEvents...)
+	for k, v := range base.x_events {
+		x_events[k] = v
+	}
+	x_allEvents = append(x_allEvents, base.x_allEvents...)
 	base.lock.Unlock()
 	return &socketHandler{
 		baseHandler: &baseHandler{
@@ -105,49 +150,137 @@ func newSocketHandler(s *socket, base *baseHandler) *socketHandler {
 			allEvents:   allEvents,
 			x_events:    x_events,
 			x_allEvents: x_allEvents,
+			name:        base.name,
 			broadcast:   base.broadcast,
 		},
-		acks:   make(map[int]*caller),
+		acks:   make(map[int]*ack),
 		socket: s,
 		rooms:  make(map[string]struct{}),
 	}
 }
 
 func (h *socketHandler) Emit(message string, args ...interface{}) error {
-	var c *caller
+	return h.emit(0, false, message, args...)
+}
+
+// emit emits the message with given args. If the last arg is an ack func and timeout > 0, the ack is
+// dropped after timeout, and the func is called with context.DeadlineExceeded if it takes an error first.
+// A volatile message is dropped if it can't reach the client at once, then the ack func is called with
+// DroppedError if it takes an error first, else emit returns it.
+func (h *socketHandler) emit(timeout time.Duration, volatile bool, message string, args ...interface{}) error {
+	var a *ack
 	if l := len(args); l > 0 {
 		fv := reflect.ValueOf(args[l-1])
 		if fv.Kind() == reflect.Func {
-			var err error
-			c, err = newCaller(args[l-1])
+			c, err := newAckCaller(args[l-1])
 			if err != nil {
 				return err
 			}
+			a = &ack{c: c}
 			args = args[:l-1]
 		}
 	}
 	args = append([]interface{}{message}, args...)
+	if a != nil {
+		_, err := h.sendAck(a, timeout, volatile, args)
+		if err == DroppedError && a.c.NeedError {
+			a.fail(h.socket, err)
+			return nil
+		}
+		return err
+	}
 	h.lock.Lock()
 	defer h.lock.Unlock()
-	if c != nil {
-		id, err := h.socket.sendId(args)
-		if err != nil {
+	if volatile {
+		if err := h.socket.sendVolatile(args); err != DroppedError {
 			return err
 		}
-		h.acks[id] = c
 		return nil
 	}
 	return h.socket.send(args)
 }
 
+// EmitWithAck emits the message with given args and waits for the ack until ctx is done. It returns
+// the raw JSON of each ack arg, binary attachments are left as placeholders.
+func (h *socketHandler) EmitWithAck(ctx context.Context, message string, args ...interface{}) ([]json.RawMessage, error) {
+	return h.emitWithAck(ctx, false, message, args...)
+}
+
+// emitWithAck is EmitWithAck, which returns DroppedError if the message is volatile and dropped.
+func (h *socketHandler) emitWithAck(ctx context.Context, volatile bool, message string, args ...interface{}) ([]json.RawMessage, error) {
+	a := &ack{raw: make(chan ackResult, 1)}
+	id, err := h.sendAck(a, 0, volatile, append([]interface{}{message}, args...))
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case ret := <-a.raw:
+		return ret.args, ret.err
+	case <-ctx.Done():
+		h.removeAck(id)
+		return nil, ctx.Err()
+	}
+}
+
+// sendAck sends args with a new packet id and keeps a until the ack of the id arrives. If timeout > 0,
+// a is failed with context.DeadlineExceeded when the ack doesn't arrive in time. A volatile packet which
+// is dropped returns DroppedError, and a isn't kept.
+func (h *socketHandler) sendAck(a *ack, timeout time.Duration, volatile bool, args []interface{}) (int, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	id, err := h.socket.sendId(args, volatile)
+	if err != nil {
+		return -1, err
+	}
+	a.sent = time.Now()
+	h.acks[id] = a
+	if timeout > 0 {
+		a.timer = time.AfterFunc(timeout, func() {
+			if h.removeAck(id) == a {
+				a.fail(h.socket, context.DeadlineExceeded)
+			}
+		})
+	}
+	return id, nil
+}
+
+// removeAck removes and returns the pending ack of id, nil if there is none.
+func (h *socketHandler) removeAck(id int) *ack {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	a, ok := h.acks[id]
+	if !ok {
+		return nil
+	}
+	delete(h.acks, id)
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	return a
+}
+
+// cancelAcks fails all pending acks with err.
+func (h *socketHandler) cancelAcks(err error) {
+	h.lock.Lock()
+	acks := h.acks
+	h.acks = make(map[int]*ack)
+	h.lock.Unlock()
+	for _, a := range acks {
+		if a.timer != nil {
+			a.timer.Stop()
+		}
+		a.fail(h.socket, err)
+	}
+}
+
 func (h *socketHandler) Rooms() []string {
 	h.lock.RLock()
 	defer h.lock.RUnlock()
-	ret := make([]string, len(h.rooms))
-	i := 0
+	ret := make([]string, 0, len(h.rooms))
 	for room := range h.rooms {
-		ret[i] = room
-		i++
+		if room != allRoom {
+			ret = append(ret, room)
+		}
 	}
 	return ret
 }
@@ -159,6 +292,7 @@ func (h *socketHandler) Join(room string) error {
 	h.lock.Lock()
 	h.rooms[room] = struct{}{}
 	h.lock.Unlock()
+	h.socket.nsp.watch.get().roomJoined(h.socket, room)
 	return nil
 }
 
@@ -169,6 +303,7 @@ func (h *socketHandler) Leave(room string) error {
 	h.lock.Lock()
 	delete(h.rooms, room)
 	h.lock.Unlock()
+	h.socket.nsp.watch.get().roomLeft(h.socket, room)
 	return nil
 }
 
@@ -197,9 +332,6 @@ func (h *baseHandler) broadcastName(room string) string {
 }
 
 func (h *socketHandler) onPacket(decoder *decoder, packet *packet) ([]interface{}, error) {
-	if Db1 {
-		fmt.Printf("At:%s\n", godebug.LF())
-	}
 	var message string
 	switch packet.Type {
 	case _CONNECT:
@@ -208,160 +340,197 @@ func (h *socketHandler) onPacket(decoder *decoder, packet *packet) ([]interface{
 		message = "disconnect"
 	case _ERROR:
 		message = "error"
-	case _ACK:
-	case _BINARY_ACK:
+	case _ACK, _BINARY_ACK:
 		return nil, h.onAck(packet.Id, decoder, packet)
 	default:
 		message = decoder.Message()
 	}
-	if Db1 {
-		fmt.Printf("At:%s\n", godebug.LF())
-	}
-	h.PrintEventsRespondedTo()
-	if DbLogMessage {
-		fmt.Printf("Message [%s] ", message)
-		if Db1 {
-			fmt.Printf("%s\n", godebug.LF())
-		}
-	}
-
-	/*
-		// xyzzy - allEvents
-		for _, c2 := range h.allEvents {
-			args := c2.GetArgs() // returns Array of interface{}
-			olen := len(args)
-		}
-	*/
+	log := h.socket.log()
+	log.Debug("packet", "sid", h.socket.Id(), "nsp", h.socket.namespace, "type", packet.Type.String(), "event", message)
 
 	h.lock.RLock()
 	c, ok := h.events[message]
 	xc, ok1 := h.x_events[message]
+	xall := h.x_allEvents
+	all := h.allEvents
 	h.lock.RUnlock()
 
-	if !ok && !ok1 {
-		if Db1 {
-			fmt.Printf("Did not have a handler for %s At:%s\n", message, godebug.LF())
+	if packet.Type == _EVENT || packet.Type == _BINARY_EVENT {
+		if ok1 || len(xall) > 0 || len(all) > 0 {
+			return h.onRawEvent(decoder, packet, message, c, xc, xall, all)
 		}
+	}
+
+	if !ok {
 		// If the message is not recognized by the server, the decoder.currentCloser
 		// needs to be closed otherwise the server will be stuck until the e xyzzy
-		fmt.Printf("Error: %s was not found in h.events\n", message)
+		if packet.Type == _EVENT || packet.Type == _BINARY_EVENT {
+			log.Warn("no handler for event", "sid", h.socket.Id(), "nsp", h.socket.namespace, "event", message)
+		}
 		decoder.Close()
 		return nil, nil
 	}
 
-	_ = xc
-	/* New -----------------------------------------------------------------------------------------------------------------
-	if ok1 {
-		// type EventHandlerFunc func(so *Socket, message string, args [][]byte) error
-		err, xargs, nargs := decoder.DecodeDataX(packet)
-		if err != nil {
-			fmt.Printf("Unable to decode packet, %s, %s\n", err, godebug.LF())
+	args := c.GetArgs() // returns Array of interface{}
+	olen := len(args)
+	if olen > 0 {
+		packet.Data = &args
+		if err := decoder.DecodeData(packet); err != nil {
+			// The handler isn't called, its args may not match the data, e.g. a struct for an array.
+			log.Error("can't decode args", "sid", h.socket.Id(), "nsp", h.socket.namespace, "event", message, "err", err)
+			return nil, &ArgsError{Event: message, Err: err}
+		}
+	} else {
+		// A handler without args doesn't read the data, close it so the connection isn't blocked.
+		decoder.Close()
+	}
+
+	return h.call(c, message, message, args, olen)
+}
+
+// onRawEvent decodes the args of the event once as raw JSON, and passes them to the raw handlers xall
+// and the any handlers all, then to xc or c.
+func (h *socketHandler) onRawEvent(decoder *decoder, packet *packet, message string, c *caller, xc ackHandlerFunc, xall []EventHandlerFunc, all []*caller) ([]interface{}, error) {
+	raw, binary, err := decoder.DecodeRaw(packet)
+	if err != nil {
+		return nil, err
+	}
+	xargs := rawArgs(raw, binary)
+	for _, f := range xall {
+		if err := f(h.socket, message, xargs); err != nil {
 			return nil, err
 		}
-		err := xc(xyzzy, message, xargs, nargs)
+	}
+	var ret []interface{}
+	for _, a := range all {
+		args, err := decodeAnyArgs(a, message, raw, binary)
+		if err != nil {
+			return nil, &ArgsError{Event: message, Err: err}
+		}
+		r, err := h.call(a, anyEvent, message, args, len(a.Args))
 		if err != nil {
-			fmt.Printf("Handler reported an error: %s, message=%s\n", err, message, godebug.LF())
 			return nil, err
 		}
-		return nil, nil
+		if len(r) > 0 {
+			ret = r
+		}
+	}
+	if xc != nil {
+		start := time.Now()
+		ret, err := xc(h.socket, message, raw, binary)
+		h.socket.metrics().HandlerCalled(message, time.Since(start))
+		return ret, err
+	}
+	if c == nil {
+		return ret, nil
+	}
 
+	args := c.GetArgs()
+	olen := len(args)
+	if args, err = decodeRawArgs(args, raw, binary); err != nil {
+		return nil, &ArgsError{Event: message, Err: err}
 	}
-	*/
+	return h.call(c, message, message, args, olen)
+}
 
-	args := c.GetArgs() // returns Array of interface{}
-	if Db1 {
-		fmt.Printf("len(args) = %d At:%s\n", len(args), godebug.LF())
+// rawArgs returns the args of an EventHandlerFunc: the raw JSON of each arg, then the attachments.
+func rawArgs(raw []json.RawMessage, binary [][]byte) [][]byte {
+	ret := make([][]byte, 0, len(raw)+len(binary))
+	for _, r := range raw {
+		ret = append(ret, r)
 	}
-	olen := len(args)
-	if Db1 {
-		fmt.Printf("args = %v, %s\n", args, godebug.LF())
+	return append(ret, binary...)
+}
+
+// decodeRawArgs decodes raw into args, which is cut to the length of raw.
+func decodeRawArgs(args []interface{}, raw []json.RawMessage, binary [][]byte) ([]interface{}, error) {
+	if len(raw) < len(args) {
+		args = args[:len(raw)]
 	}
-	if olen > 0 {
-		packet.Data = &args
-		if err := decoder.DecodeData(packet); err != nil {
-			if Db1 {
-				fmt.Printf("At:%s, err=%s, an error at this point means that your handler did not get called\n", godebug.LF(), err)
-			}
-			fmt.Printf("Try a `map[string]interface{}` for a parameter type, %s\n", godebug.LF())
+	for i := range args {
+		if err := json.Unmarshal(raw[i], args[i]); err != nil {
 			return nil, err
 		}
 	}
-
-	// Padd out args to olen
-	for i := len(args); i < olen; i++ {
-		args = append(args, nil)
+	if len(binary) > 0 {
+		if err := decodeAttachments(args, binary); err != nil {
+			return nil, err
+		}
 	}
+	return args, nil
+}
 
-	if DbLogMessage {
-		if Db1 {
-			fmt.Printf("\tArgs = %s, %s\n", godebug.SVar(args), godebug.LF())
-		} else {
-			fmt.Printf("Args = %s\n", godebug.SVar(args))
+var interfacesType = reflect.TypeOf([]interface{}{})
+
+// decodeAnyArgs decodes the args of the any handler c, which takes message first. Attachments in the
+// []interface{} form are left as placeholders.
+func decodeAnyArgs(c *caller, message string, raw []json.RawMessage, binary [][]byte) ([]interface{}, error) {
+	if len(c.Args) == 2 && c.Args[1] == interfacesType {
+		all := make([]interface{}, len(raw))
+		for i := range raw {
+			if err := json.Unmarshal(raw[i], &all[i]); err != nil {
+				return nil, err
+			}
 		}
+		return []interface{}{&message, &all}, nil
 	}
-	if LogMessage {
-		logrus.Infof("Message [%s] Auruments %s", message, godebug.SVar(args))
+	args, err := decodeRawArgs(c.GetArgs()[1:], raw, binary)
+	if err != nil {
+		return nil, err
 	}
+	return append([]interface{}{&message}, args...), nil
+}
 
-	// ------------------------------------------------------ call ---------------------------------------------------------------------------------------
-	retV := c.Call(h.socket, args)
-	if len(retV) == 0 {
-		if Db1 {
-			fmt.Printf("At:%s\n", godebug.LF())
-		}
-		return nil, nil
-	}
+// anyEvent is the event the handlers of every event are measured as, so clients can't add an event to
+// the metrics with each message name they make up.
+const anyEvent = "*"
 
-	var err error
-	if last, ok := retV[len(retV)-1].Interface().(error); ok {
-		err = last
-		retV = retV[0 : len(retV)-1]
-	}
-	ret := make([]interface{}, len(retV))
-	for i, v := range retV {
-		ret[i] = v.Interface()
-	}
-	if Db1 {
-		fmt.Printf("At:%s\n", godebug.LF())
+// call calls the handler c of message with args padded to olen, and returns what it returns. event is
+// the name c is measured as: message for a handler registered for it, else anyEvent.
+func (h *socketHandler) call(c *caller, event, message string, args []interface{}, olen int) ([]interface{}, error) {
+	// Padd out args to olen
+	for i := len(args); i < olen; i++ {
+		args = append(args, nil)
 	}
-	if DbLogMessage {
-		if err != nil {
-			fmt.Printf("Response/Error %s", err)
-		} else {
-			fmt.Printf("Response %s", godebug.SVar(ret))
-		}
+
+	// ------------------------------------------------------ call ---------------------------------------------------------------------------------------
+	start := time.Now()
+	ret, err := c.Call(h.socket, args)
+	h.socket.metrics().HandlerCalled(event, time.Since(start))
+	if ae, ok := err.(*ArgsError); ok && ae.Event == "" {
+		ae.Event = message
 	}
-	if LogMessage {
-		if err != nil {
-			logrus.Infof("Response/Error %s", err)
-		} else {
-			logrus.Infof("Response %s", godebug.SVar(ret))
-		}
+	if err != nil {
+		h.socket.log().Error("handler failed", "sid", h.socket.Id(), "nsp", h.socket.namespace, "event", message, "err", err)
 	}
 	return ret, err
 }
 
 func (h *socketHandler) onAck(id int, decoder *decoder, packet *packet) error {
-	h.lock.Lock()
-	defer h.lock.Unlock()
-	c, ok := h.acks[id]
-	if !ok {
+	a := h.removeAck(id)
+	if a == nil {
+		decoder.Close()
 		return nil
 	}
-	delete(h.acks, id)
+	h.socket.metrics().AckReceived(time.Since(a.sent))
+	if a.raw != nil {
+		var args []json.RawMessage
+		packet.Data = &args
+		err := decoder.DecodeData(packet)
+		a.raw <- ackResult{args: args, err: err}
+		return err
+	}
 
-	args := c.GetArgs()
+	args := a.c.GetArgs()
 	packet.Data = &args
 	if err := decoder.DecodeData(packet); err != nil {
 		return err
 	}
-	c.Call(h.socket, args)
+	for i := len(args); i < len(a.c.Args); i++ {
+		args = append(args, nil)
+	}
+	a.c.Call(h.socket, args)
 	return nil
 }
 
-var Db1 = false
-
-var DbLogMessage = true
-var LogMessage = true
-
 /* vim: set noai ts=4 sw=4: */
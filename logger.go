@@ -0,0 +1,27 @@
+package socketio
+
+import (
+	"log/slog"
+)
+
+// Logger receives the log records of a Server. keyvals are alternating keys and values, like "sid",
+// so.Id(), "nsp", "/chat". *slog.Logger is a Logger, see NewSlogLogger.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// NewSlogLogger returns the Logger writing to the slog handler h.
+func NewSlogLogger(h slog.Handler) Logger {
+	return slog.New(h)
+}
+
+// nopLogger drops every record, it's the default Logger.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
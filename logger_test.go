@@ -0,0 +1,89 @@
+package socketio
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogger(t *testing.T) {
+	Convey("Log to the logger of server", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		logger := &recordLogger{}
+		server.SetLogger(logger)
+		ids := make(chan string, 1)
+		server.On("connection", func(so Socket) {
+			ids <- so.Id()
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		id := <-ids
+		So(client.Emit("unknown", "hi"), ShouldBeNil)
+
+		var records []string
+		for i := 0; i < 100; i++ {
+			records = logger.find(id)
+			if len(records) > 0 && records[len(records)-1] == "WARN no handler for event" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(records, ShouldContain, "INFO socket connected")
+		So(records, ShouldContain, "WARN no handler for event")
+	})
+
+	Convey("Log to slog", t, func() {
+		var buf bytes.Buffer
+		logger := NewSlogLogger(slog.NewTextHandler(&buf, nil))
+		logger.Info("socket connected", "sid", "abc", "nsp", "/chat")
+		So(buf.String(), ShouldContainSubstring, `msg="socket connected" sid=abc nsp=/chat`)
+	})
+}
+
+// recordLogger keeps the level and message of the records.
+type recordLogger struct {
+	records []logRecord
+	lock    sync.Mutex
+}
+
+type logRecord struct {
+	msg     string
+	keyvals []interface{}
+}
+
+func (l *recordLogger) log(level, msg string, keyvals []interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.records = append(l.records, logRecord{level + " " + msg, keyvals})
+}
+
+func (l *recordLogger) Debug(msg string, keyvals ...interface{}) { l.log("DEBUG", msg, keyvals) }
+func (l *recordLogger) Info(msg string, keyvals ...interface{})  { l.log("INFO", msg, keyvals) }
+func (l *recordLogger) Warn(msg string, keyvals ...interface{})  { l.log("WARN", msg, keyvals) }
+func (l *recordLogger) Error(msg string, keyvals ...interface{}) { l.log("ERROR", msg, keyvals) }
+
+// find returns the records with the sid.
+func (l *recordLogger) find(sid string) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var ret []string
+	for _, r := range l.records {
+		for i := 0; i+1 < len(r.keyvals); i += 2 {
+			if r.keyvals[i] == "sid" && r.keyvals[i+1] == sid {
+				ret = append(ret, r.msg)
+				break
+			}
+		}
+	}
+	return ret
+}
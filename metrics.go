@@ -0,0 +1,101 @@
+package socketio
+
+import (
+	"io"
+	"time"
+
+	"github.com/pschlump/socketio/engineio"
+)
+
+// Metrics receives the measurements of a Server, see Server.SetMetrics and PrometheusMetrics.
+// Methods are called from several goroutines.
+type Metrics interface {
+	engineio.Metrics
+
+	// PacketReceived is called for each packet read, typ is the name of its type like "event", and
+	// bytes counts the packet with its attachments.
+	PacketReceived(typ string, bytes int)
+
+	// PacketSent is called for each packet written, like PacketReceived.
+	PacketSent(typ string, bytes int)
+
+	// AckReceived is called when the ack of an emit arrives after latency.
+	AckReceived(latency time.Duration)
+
+	// Broadcasted is called for each broadcast, with the number of local sockets it's sent to.
+	Broadcasted(sockets int)
+
+	// HandlerCalled is called when the handler of event returns after d. The handlers of every event,
+	// see Socket.OnAny, are reported with the event "*".
+	HandlerCalled(event string, d time.Duration)
+}
+
+// nopMetrics drops every measurement, it's the default Metrics.
+type nopMetrics struct{}
+
+func (nopMetrics) ConnectionOpened(string)             {}
+func (nopMetrics) ConnectionClosed(string)             {}
+func (nopMetrics) ConnectionUpgraded(string, string)   {}
+func (nopMetrics) HandshakeRejected(string)            {}
+func (nopMetrics) PacketReceived(string, int)          {}
+func (nopMetrics) PacketSent(string, int)              {}
+func (nopMetrics) AckReceived(time.Duration)           {}
+func (nopMetrics) Broadcasted(int)                     {}
+func (nopMetrics) HandlerCalled(string, time.Duration) {}
+
+// metricsSetter is the adaptor which reports its broadcasts.
+type metricsSetter interface {
+	SetMetrics(m Metrics)
+}
+
+// countReader counts the bytes read from the frames of r.
+type countReader struct {
+	r frameReader
+	n int
+}
+
+func (c *countReader) NextReader() (engineio.MessageType, io.ReadCloser, error) {
+	t, r, err := c.r.NextReader()
+	if err != nil {
+		return t, r, err
+	}
+	return t, &countReadCloser{ReadCloser: r, n: &c.n}, nil
+}
+
+type countReadCloser struct {
+	io.ReadCloser
+	n *int
+}
+
+func (c *countReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	*c.n += n
+	return n, err
+}
+
+// countWriter counts the frames and bytes written to w.
+type countWriter struct {
+	w      frameWriter
+	frames int
+	n      int
+}
+
+func (c *countWriter) NextWriter(t engineio.MessageType) (io.WriteCloser, error) {
+	w, err := c.w.NextWriter(t)
+	if err != nil {
+		return w, err
+	}
+	c.frames++
+	return &countWriteCloser{WriteCloser: w, n: &c.n}, nil
+}
+
+type countWriteCloser struct {
+	io.WriteCloser
+	n *int
+}
+
+func (c *countWriteCloser) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	*c.n += n
+	return n, err
+}
@@ -1,6 +1,10 @@
 package socketio
 
-import "sync"
+import (
+	"errors"
+	"strings"
+	"sync"
+)
 
 // Namespace is the name space of socket.io handler.
 type Namespace interface {
@@ -13,18 +17,130 @@ type Namespace interface {
 
 	// On registers the function f to handle message.
 	On(message string, f interface{}) error
+
+	// OnAny registers the function f to handle every message, see Socket.OnAny.
+	OnAny(f interface{}) error
+
+	// Handle registers the raw handler f to handle message, see EventHandlerFunc.
+	Handle(message string, f EventHandlerFunc) error
+
+	// HandleAny registers the raw handler f to handle every message, see EventHandlerFunc.
+	HandleAny(f EventHandlerFunc) error
+
+	// Use adds the middleware f, which runs before the connection handler. f must call next once, with
+	// nil to run the next middleware, or an error to reject the connection, see ConnectError.
+	Use(f func(so Socket, next func(error)))
+
+	// To returns the operator broadcasting to the sockets in room, see BroadcastOperator.
+	To(room string) *BroadcastOperator
+
+	// In is the same as To.
+	In(room string) *BroadcastOperator
+
+	// Except returns the operator broadcasting to every socket but the ones in room.
+	Except(room string) *BroadcastOperator
+
+	// Where returns the operator broadcasting to every socket whose value of key is val.
+	Where(key string, val interface{}) *BroadcastOperator
+
+	// Volatile returns the operator broadcasting to every socket which can get the message at once.
+	Volatile() *BroadcastOperator
+
+	// Socket returns the connected socket with id, or nil.
+	Socket(id string) Socket
+
+	// Sockets returns the connected sockets.
+	Sockets() []Socket
+
+	// DisconnectSocket disconnects the socket with id from the namespace. The connection is closed
+	// if it has no other namespace.
+	DisconnectSocket(id string) error
+
+	// ListOfRooms returns the rooms of the namespace with sockets.
+	ListOfRooms() ([]string, error)
+
+	// NumberOfRooms returns the number of rooms of the namespace with sockets.
+	NumberOfRooms() (int, error)
+
+	// SocketsInRoom returns the ids of the sockets in room.
+	SocketsInRoom(room string) ([]string, error)
+
+	// NumberInRoom returns the number of sockets in room.
+	NumberInRoom(room string) (int, error)
+
+	// RoomsOfSocket returns the rooms of the namespace joined by the socket with id.
+	RoomsOfSocket(id string) ([]string, error)
+}
+
+// NoSocketError is returned by DisconnectSocket when there is no socket with the id.
+var NoSocketError = errors.New("no such socket")
+
+// NoIntrospectionError is returned by the room queries of Namespace when the adaptor isn't an
+// IntrospectBroadcastAdaptor.
+var NoIntrospectionError = errors.New("adaptor can't list rooms")
+
+// ConnectError is the error passed to the next func of a middleware to reject the connection with
+// a message and data, which the client gets in the ERROR packet.
+type ConnectError struct {
+	Message string
+	Data    interface{}
+}
+
+func (e *ConnectError) Error() string {
+	return e.Message
+}
+
+// holder holds a setting of a server shared by its namespaces, like the Logger, falling back to nop
+// when it's set to nil.
+type holder[T any] struct {
+	v    T
+	nop  T
+	lock sync.RWMutex
+}
+
+func newHolder[T any](nop T) *holder[T] {
+	return &holder[T]{
+		v:   nop,
+		nop: nop,
+	}
+}
+
+func (h *holder[T]) set(v T) {
+	if any(v) == nil {
+		v = h.nop
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.v = v
+}
+
+func (h *holder[T]) get() T {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.v
 }
 
 type namespace struct {
 	*baseHandler
-	root map[string]Namespace
-	lock sync.RWMutex
+	root        map[string]Namespace
+	sockets     map[string]*socket
+	middlewares []func(Socket, func(error))
+	recovery    *recovery
+	logger      *holder[Logger]
+	meter       *holder[Metrics]
+	watch       *holder[observer]
+	lock        sync.RWMutex
 }
 
 func newNamespace(broadcast BroadcastAdaptor) *namespace {
 	ret := &namespace{
 		baseHandler: newBaseHandler("", broadcast),
 		root:        make(map[string]Namespace),
+		sockets:     make(map[string]*socket),
+		recovery:    newRecovery(),
+		logger:      newHolder[Logger](nopLogger{}),
+		meter:       newHolder[Metrics](nopMetrics{}),
+		watch:       newHolder[observer](nopObserver{}),
 	}
 	ret.root[ret.Name()] = ret
 	return ret
@@ -46,7 +162,170 @@ func (n *namespace) Of(name string) Namespace {
 	ret := &namespace{
 		baseHandler: newBaseHandler(name, n.baseHandler.broadcast),
 		root:        n.root,
+		sockets:     make(map[string]*socket),
+		recovery:    n.recovery,
+		logger:      n.logger,
+		meter:       n.meter,
+		watch:       n.watch,
 	}
 	n.root[name] = ret
 	return ret
 }
+
+func (n *namespace) Use(f func(so Socket, next func(error))) {
+	n.lock.Lock()
+	n.middlewares = append(n.middlewares, f)
+	n.lock.Unlock()
+}
+
+// runMiddlewares runs the middlewares on so one by one, and returns the first error passed to next.
+func (n *namespace) runMiddlewares(so Socket) error {
+	n.lock.RLock()
+	middlewares := n.middlewares
+	n.lock.RUnlock()
+	for _, f := range middlewares {
+		done := make(chan error, 1)
+		var once sync.Once
+		f(so, func(err error) {
+			once.Do(func() {
+				done <- err
+			})
+		})
+		if err := <-done; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the namespace with given name if it exists, or nil.
+func (n *namespace) get(name string) *namespace {
+	if name == "/" {
+		name = ""
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	ret, _ := n.root[name].(*namespace)
+	return ret
+}
+
+// all returns every namespace of the server.
+func (n *namespace) all() []*namespace {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	ret := make([]*namespace, 0, len(n.root))
+	for _, nsp := range n.root {
+		if nsp, ok := nsp.(*namespace); ok {
+			ret = append(ret, nsp)
+		}
+	}
+	return ret
+}
+
+func (n *namespace) add(s *socket) {
+	n.lock.Lock()
+	n.sockets[s.Id()] = s
+	n.lock.Unlock()
+}
+
+func (n *namespace) remove(s *socket) {
+	n.lock.Lock()
+	if n.sockets[s.Id()] == s {
+		delete(n.sockets, s.Id())
+	}
+	n.lock.Unlock()
+}
+
+func (n *namespace) Socket(id string) Socket {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	if s, ok := n.sockets[id]; ok {
+		return s
+	}
+	return nil
+}
+
+func (n *namespace) Sockets() []Socket {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	ret := make([]Socket, 0, len(n.sockets))
+	for _, s := range n.sockets {
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+func (n *namespace) DisconnectSocket(id string) error {
+	n.lock.RLock()
+	s, ok := n.sockets[id]
+	n.lock.RUnlock()
+	if !ok {
+		return NoSocketError
+	}
+	return s.Disconnect()
+}
+
+func (n *namespace) introspect() (IntrospectBroadcastAdaptor, error) {
+	ret, ok := n.broadcast.(IntrospectBroadcastAdaptor)
+	if !ok {
+		return nil, NoIntrospectionError
+	}
+	return ret, nil
+}
+
+// roomsOf returns the rooms of the namespace in rooms of the adaptor, without the namespace.
+func (n *namespace) roomsOf(rooms []string) []string {
+	prefix := n.broadcastName("")
+	ret := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		if strings.HasPrefix(room, prefix) && room != prefix {
+			ret = append(ret, room[len(prefix):])
+		}
+	}
+	return ret
+}
+
+func (n *namespace) ListOfRooms() ([]string, error) {
+	adaptor, err := n.introspect()
+	if err != nil {
+		return nil, err
+	}
+	rooms, err := adaptor.ListOfRooms()
+	if err != nil {
+		return nil, err
+	}
+	return n.roomsOf(rooms), nil
+}
+
+func (n *namespace) NumberOfRooms() (int, error) {
+	rooms, err := n.ListOfRooms()
+	return len(rooms), err
+}
+
+func (n *namespace) SocketsInRoom(room string) ([]string, error) {
+	adaptor, err := n.introspect()
+	if err != nil {
+		return nil, err
+	}
+	return adaptor.SocketsInRoom(n.broadcastName(room))
+}
+
+func (n *namespace) NumberInRoom(room string) (int, error) {
+	adaptor, err := n.introspect()
+	if err != nil {
+		return 0, err
+	}
+	return adaptor.NumberInRoom(n.broadcastName(room))
+}
+
+func (n *namespace) RoomsOfSocket(id string) ([]string, error) {
+	adaptor, err := n.introspect()
+	if err != nil {
+		return nil, err
+	}
+	rooms, err := adaptor.RoomsOfSocket(id)
+	if err != nil {
+		return nil, err
+	}
+	return n.roomsOf(rooms), nil
+}
@@ -0,0 +1,354 @@
+package socketio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNamespace(t *testing.T) {
+	Convey("Namespaces on one connection", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		chat := server.Of("/chat")
+		So(server.Of("/chat"), ShouldEqual, chat)
+		So(chat.Name(), ShouldEqual, "/chat")
+
+		events := make(chan string, 10)
+		server.On("connection", func(so Socket) {
+			so.On("who", func() string {
+				return "root"
+			})
+			so.On("disconnect", func() {
+				events <- "root disconnect"
+			})
+		})
+		chat.On("connection", func(so Socket) {
+			events <- "chat connection"
+			so.On("disconnect", func() {
+				events <- "chat disconnect"
+			})
+		})
+		chat.On("who", func() string {
+			return "chat"
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		Convey("have their own handlers", func() {
+			c := client.Of("/chat")
+			So(<-events, ShouldEqual, "chat connection")
+
+			who := make(chan string, 2)
+			So(client.Emit("who", func(name string) {
+				who <- name
+			}), ShouldBeNil)
+			So(<-who, ShouldEqual, "root")
+			So(c.Emit("who", func(name string) {
+				who <- name
+			}), ShouldBeNil)
+			So(<-who, ShouldEqual, "chat")
+		})
+
+		Convey("have their own sockets", func() {
+			client.Of("/chat")
+			So(<-events, ShouldEqual, "chat connection")
+			So(len(server.namespace.sockets), ShouldEqual, 1)
+			So(len(chat.(*namespace).sockets), ShouldEqual, 1)
+
+			So(client.send(packet{Type: _DISCONNECT, Id: -1, NSP: "/chat"}), ShouldBeNil)
+			So(<-events, ShouldEqual, "chat disconnect")
+			So(len(chat.(*namespace).sockets), ShouldEqual, 0)
+
+			who := make(chan string, 1)
+			So(client.Emit("who", func(name string) {
+				who <- name
+			}), ShouldBeNil)
+			So(<-who, ShouldEqual, "root")
+		})
+
+		Convey("reject unknown namespace", func() {
+			c := client.Of("/unknown")
+			who := make(chan string, 1)
+			So(client.Emit("who", func(name string) {
+				who <- name
+			}), ShouldBeNil)
+			So(<-who, ShouldEqual, "root")
+			So(c.Connected(), ShouldBeFalse)
+			So(server.get("/unknown"), ShouldBeNil)
+		})
+	})
+}
+
+func TestNamespaceUse(t *testing.T) {
+	Convey("Middlewares", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		calls := make(chan string, 10)
+		server.Use(func(so Socket, next func(error)) {
+			calls <- "first"
+			go next(nil)
+		})
+		server.Use(func(so Socket, next func(error)) {
+			calls <- "second"
+			next(nil)
+		})
+		server.On("connection", func(so Socket) {
+			calls <- "connection"
+		})
+		admin := server.Of("/admin")
+		admin.Use(func(so Socket, next func(error)) {
+			if so.Auth()["role"] == "staff" {
+				next(nil)
+				return
+			}
+			next(&ConnectError{Message: "not staff", Data: map[string]interface{}{"code": 403}})
+		})
+		admin.On("connection", func(so Socket) {
+			calls <- "admin connection"
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		Convey("run before connection", func() {
+			client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+			So(err, ShouldBeNil)
+			defer client.Close()
+			So(<-calls, ShouldEqual, "first")
+			So(<-calls, ShouldEqual, "second")
+			So(<-calls, ShouldEqual, "connection")
+		})
+
+		Convey("reject connection", func() {
+			poll := func(method, query, body string) string {
+				req, err := http.NewRequest(method, h.URL+"/socket.io/?EIO=4&transport=polling"+query, strings.NewReader(body))
+				So(err, ShouldBeNil)
+				resp, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				b, err := ioutil.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				return string(b)
+			}
+			var info struct {
+				Sid string `json:"sid"`
+			}
+			So(json.Unmarshal([]byte(poll("GET", "", "")[1:]), &info), ShouldBeNil)
+
+			So(poll("POST", "&sid="+info.Sid, `40/admin,{"role":"guest"}`), ShouldEqual, "ok")
+			So(poll("GET", "&sid="+info.Sid, ""), ShouldEqual, `44/admin,{"data":{"code":403},"message":"not staff"}`)
+
+			So(poll("POST", "&sid="+info.Sid, `40/admin,{"role":"staff"}`), ShouldEqual, "ok")
+			So(poll("GET", "&sid="+info.Sid, ""), ShouldStartWith, `40/admin,{"sid":`)
+			So(<-calls, ShouldEqual, "admin connection")
+		})
+	})
+}
+
+func TestRootNamespace(t *testing.T) {
+	Convey("Connect the root namespace as / or the empty name once", t, func() {
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		connections := make(chan string, 2)
+		server.On("connection", func(so Socket) {
+			connections <- so.Id()
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		poll := func(method, query, body string) string {
+			req, err := http.NewRequest(method, h.URL+"/socket.io/?EIO=4&transport=polling"+query, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return string(b)
+		}
+		var info struct {
+			Sid string `json:"sid"`
+		}
+		So(json.Unmarshal([]byte(poll("GET", "", "")[1:]), &info), ShouldBeNil)
+
+		So(poll("POST", "&sid="+info.Sid, "40"), ShouldEqual, "ok")
+		So(poll("GET", "&sid="+info.Sid, ""), ShouldStartWith, `40{"sid":`)
+		id := <-connections
+		So(poll("POST", "&sid="+info.Sid, "40/"), ShouldEqual, "ok")
+		So(len(server.Sockets()), ShouldEqual, 1)
+
+		So(poll("POST", "&sid="+info.Sid, "41/"), ShouldEqual, "ok")
+		for server.Socket(id) != nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(len(connections), ShouldEqual, 0)
+	})
+}
+
+func TestNamespaceRooms(t *testing.T) {
+	Convey("Rooms of a namespace", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		chat := server.Of("/chat")
+		ids := make(chan string, 2)
+		server.On("connection", func(so Socket) {
+			so.Join("lobby")
+			so.Join("games")
+			ids <- so.Id()
+		})
+		chat.On("connection", func(so Socket) {
+			so.Join("lobby")
+			ids <- so.Id()
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		id := <-ids
+		client.Of("/chat")
+		So(<-ids, ShouldEqual, id)
+
+		rooms, err := server.ListOfRooms()
+		So(err, ShouldBeNil)
+		sort.Strings(rooms)
+		So(rooms, ShouldResemble, []string{"games", "lobby"})
+		n, err := server.NumberOfRooms()
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+		rooms, err = chat.ListOfRooms()
+		So(err, ShouldBeNil)
+		So(rooms, ShouldResemble, []string{"lobby"})
+
+		members, err := server.SocketsInRoom("lobby")
+		So(err, ShouldBeNil)
+		So(members, ShouldResemble, []string{id})
+		n, err = chat.NumberInRoom("lobby")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		n, err = chat.NumberInRoom("games")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+
+		rooms, err = server.RoomsOfSocket(id)
+		So(err, ShouldBeNil)
+		sort.Strings(rooms)
+		So(rooms, ShouldResemble, []string{"games", "lobby"})
+
+		server.SetAdaptor(plainAdaptor{newBroadcastDefault()})
+		_, err = server.ListOfRooms()
+		So(err, ShouldEqual, NoIntrospectionError)
+	})
+}
+
+func TestNamespaceSockets(t *testing.T) {
+	Convey("Sockets of a namespace", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		chat := server.Of("/chat")
+		ids := make(chan string, 2)
+		events := make(chan string, 10)
+		server.On("ping", func() int {
+			return 1
+		})
+		chat.On("connection", func(so Socket) {
+			so.On("disconnect", func() {
+				events <- "server disconnect"
+			})
+			ids <- so.Id()
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		c := client.Of("/chat")
+		c.On("disconnect", func() {
+			events <- "client disconnect"
+		})
+		id := <-ids
+
+		So(chat.Socket(id), ShouldNotBeNil)
+		So(chat.Socket(id).Id(), ShouldEqual, id)
+		So(chat.Socket("nobody"), ShouldBeNil)
+		So(len(chat.Sockets()), ShouldEqual, 1)
+		So(len(server.Sockets()), ShouldEqual, 2)
+
+		So(chat.Socket(id).Emit("news", "hello"), ShouldBeNil)
+		So(chat.DisconnectSocket(id), ShouldBeNil)
+		got := []string{<-events, <-events}
+		sort.Strings(got)
+		So(got, ShouldResemble, []string{"client disconnect", "server disconnect"})
+		So(chat.Socket(id), ShouldBeNil)
+		So(chat.DisconnectSocket(id), ShouldEqual, NoSocketError)
+
+		// the root namespace is still connected
+		So(server.Socket(id), ShouldNotBeNil)
+		who := make(chan int, 1)
+		So(client.Emit("ping", func(n int) {
+			who <- n
+		}), ShouldBeNil)
+		So(<-who, ShouldEqual, 1)
+	})
+}
+
+func TestServerSockets(t *testing.T) {
+	Convey("Sockets of every namespace", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		ids := make(chan string, 2)
+		server.Of("/chat").On("connection", func(so Socket) {
+			ids <- so.Id()
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		Convey("find a client which only joined a namespace", func() {
+			client, err := Dial(h.URL+"/chat", &ClientOptions{NoReconnect: true, Protocol: ProtocolV5})
+			So(err, ShouldBeNil)
+			defer client.Close()
+			id := <-ids
+
+			So(server.namespace.Socket(id), ShouldBeNil)
+			So(server.Socket(id), ShouldNotBeNil)
+			So(server.Socket(id).Id(), ShouldEqual, id)
+			So(len(server.Sockets()), ShouldEqual, 1)
+		})
+
+		Convey("disconnect a client from every namespace", func() {
+			client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+			So(err, ShouldBeNil)
+			defer client.Close()
+			disconnected := make(chan string, 2)
+			client.On("disconnect", func() {
+				disconnected <- "/"
+			})
+			chat := client.Of("/chat")
+			chat.On("disconnect", func() {
+				disconnected <- "/chat"
+			})
+			id := <-ids
+
+			So(server.DisconnectSocket(id), ShouldBeNil)
+			got := []string{<-disconnected, <-disconnected}
+			sort.Strings(got)
+			So(got, ShouldResemble, []string{"/", "/chat"})
+			So(server.Socket(id), ShouldBeNil)
+			So(server.DisconnectSocket(id), ShouldEqual, NoSocketError)
+		})
+	})
+}
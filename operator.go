@@ -0,0 +1,201 @@
+package socketio
+
+import "errors"
+
+// allRoom is the room every socket of a namespace joins on connect, so a broadcast without rooms
+// goes to the whole namespace. It isn't listed in Socket.Rooms.
+const allRoom = ""
+
+// BroadcastOperator selects the sockets of a broadcast by rooms, e.g.
+//
+//	server.To("a").To("b").Except("c").Emit("news", msg)
+//
+// sends news once to each socket in room a or b which isn't in room c. Without rooms it sends to every
+// socket of the namespace. Each method returns a new BroadcastOperator, so one can be reused.
+type BroadcastOperator struct {
+	handler  *baseHandler
+	ignore   Socket
+	rooms    []string
+	except   []string
+	data     map[string]interface{}
+	volatile bool
+}
+
+// To returns the operator also sending to the sockets in room.
+func (o *BroadcastOperator) To(room string) *BroadcastOperator {
+	ret := *o
+	ret.rooms = append(o.rooms[:len(o.rooms):len(o.rooms)], room)
+	return &ret
+}
+
+// In is the same as To.
+func (o *BroadcastOperator) In(room string) *BroadcastOperator {
+	return o.To(room)
+}
+
+// Except returns the operator leaving out the sockets in room.
+func (o *BroadcastOperator) Except(room string) *BroadcastOperator {
+	ret := *o
+	ret.except = append(o.except[:len(o.except):len(o.except)], room)
+	return &ret
+}
+
+// Where returns the operator leaving out the sockets whose Socket.Get(key) isn't val, e.g.
+//
+//	server.To("chat").Where("role", "admin").Emit("alert", msg)
+//
+// Values are compared as JSON, so they should be serializable.
+func (o *BroadcastOperator) Where(key string, val interface{}) *BroadcastOperator {
+	ret := *o
+	ret.data = make(map[string]interface{}, len(o.data)+1)
+	for k, v := range o.data {
+		ret.data[k] = v
+	}
+	ret.data[key] = val
+	return &ret
+}
+
+// Volatile returns the operator dropping the message for the sockets which can't get it at once,
+// see Socket.Volatile.
+func (o *BroadcastOperator) Volatile() *BroadcastOperator {
+	ret := *o
+	ret.volatile = true
+	return &ret
+}
+
+// Emit sends the message with given args to the selected sockets. Adaptors which aren't a
+// RoomsBroadcastAdaptor only support one room, no Except, no Where and no Volatile.
+func (o *BroadcastOperator) Emit(message string, args ...interface{}) error {
+	adaptor, ok := o.handler.broadcast.(RoomsBroadcastAdaptor)
+	if !ok {
+		rooms := o.rooms
+		if len(rooms) == 0 {
+			rooms = []string{allRoom}
+		}
+		if len(rooms) != 1 || len(o.except) != 0 || len(o.data) != 0 || o.volatile {
+			return errors.New("adaptor can't select the sockets of the broadcast")
+		}
+		return o.handler.broadcast.Send(o.ignore, o.handler.broadcastName(rooms[0]), message, args...)
+	}
+	return adaptor.SendTo(o.options(), message, args...)
+}
+
+// SocketsJoin makes the selected sockets on every server join rooms. The adaptor must be a
+// ClusterBroadcastAdaptor.
+func (o *BroadcastOperator) SocketsJoin(rooms ...string) error {
+	adaptor, err := o.cluster()
+	if err != nil {
+		return err
+	}
+	return adaptor.SocketsJoin(o.options(), rooms...)
+}
+
+// SocketsLeave makes the selected sockets on every server leave rooms. The adaptor must be a
+// ClusterBroadcastAdaptor.
+func (o *BroadcastOperator) SocketsLeave(rooms ...string) error {
+	adaptor, err := o.cluster()
+	if err != nil {
+		return err
+	}
+	return adaptor.SocketsLeave(o.options(), rooms...)
+}
+
+// DisconnectSockets disconnects the selected sockets on every server. The adaptor must be a
+// ClusterBroadcastAdaptor.
+func (o *BroadcastOperator) DisconnectSockets() error {
+	adaptor, err := o.cluster()
+	if err != nil {
+		return err
+	}
+	return adaptor.DisconnectSockets(o.options())
+}
+
+func (o *BroadcastOperator) cluster() (ClusterBroadcastAdaptor, error) {
+	ret, ok := o.handler.broadcast.(ClusterBroadcastAdaptor)
+	if !ok {
+		return nil, errors.New("adaptor can't act on sockets of rooms")
+	}
+	return ret, nil
+}
+
+// options returns the BroadcastOptions of the adaptor.
+func (o *BroadcastOperator) options() BroadcastOptions {
+	rooms := o.rooms
+	if len(rooms) == 0 {
+		rooms = []string{allRoom}
+	}
+	ret := BroadcastOptions{
+		Rooms:  make([]string, len(rooms)),
+		Except: make([]string, len(o.except)),
+	}
+	for i, room := range rooms {
+		ret.Rooms[i] = o.handler.broadcastName(room)
+	}
+	for i, room := range o.except {
+		ret.Except[i] = o.handler.broadcastName(room)
+	}
+	if o.ignore != nil {
+		ret.ExceptIds = []string{o.ignore.Id()}
+	}
+	ret.Data = o.data
+	ret.Volatile = o.volatile
+	return ret
+}
+
+// To returns the operator sending to the sockets in room.
+func (h *baseHandler) To(room string) *BroadcastOperator {
+	return h.operator(nil).To(room)
+}
+
+// In is the same as To.
+func (h *baseHandler) In(room string) *BroadcastOperator {
+	return h.To(room)
+}
+
+// Except returns the operator sending to every socket of the namespace but the ones in room.
+func (h *baseHandler) Except(room string) *BroadcastOperator {
+	return h.operator(nil).Except(room)
+}
+
+// Where returns the operator sending to every socket of the namespace whose value of key is val.
+func (h *baseHandler) Where(key string, val interface{}) *BroadcastOperator {
+	return h.operator(nil).Where(key, val)
+}
+
+// Volatile returns the operator sending to every socket of the namespace which can get the message
+// at once, see Socket.Volatile.
+func (h *baseHandler) Volatile() *BroadcastOperator {
+	return h.operator(nil).Volatile()
+}
+
+func (h *baseHandler) operator(ignore Socket) *BroadcastOperator {
+	return &BroadcastOperator{
+		handler: h,
+		ignore:  ignore,
+	}
+}
+
+// To returns the operator sending to the sockets in room but this one.
+func (h *socketHandler) To(room string) *BroadcastOperator {
+	return h.Broadcast().To(room)
+}
+
+// In is the same as To.
+func (h *socketHandler) In(room string) *BroadcastOperator {
+	return h.To(room)
+}
+
+// Except returns the operator sending to every socket of the namespace but this one and the ones in room.
+func (h *socketHandler) Except(room string) *BroadcastOperator {
+	return h.Broadcast().Except(room)
+}
+
+// Where returns the operator sending to every socket of the namespace whose value of key is val but this one.
+func (h *socketHandler) Where(key string, val interface{}) *BroadcastOperator {
+	return h.Broadcast().Where(key, val)
+}
+
+// Broadcast returns the operator sending to every socket of the namespace but this one.
+func (h *socketHandler) Broadcast() *BroadcastOperator {
+	return h.baseHandler.operator(h.socket)
+}
@@ -0,0 +1,176 @@
+package socketio
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBroadcastOperator(t *testing.T) {
+	Convey("Broadcast to rooms", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		server.On("join", func(so Socket, rooms []string) bool {
+			for _, room := range rooms {
+				so.Join(room)
+			}
+			return true
+		})
+		server.On("set", func(so Socket, key, val string) bool {
+			so.Set(key, val)
+			return true
+		})
+		server.On("shout", func(so Socket, msg string) {
+			so.Broadcast().Emit("news", msg)
+		})
+		server.On("whisper", func(so Socket, room, msg string) {
+			so.To(room).Emit("news", msg)
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		news := make(map[string]chan string)
+		dial := func(name string, rooms ...string) *Client {
+			client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+			So(err, ShouldBeNil)
+			news[name] = make(chan string, 10)
+			client.On("news", func(msg string) {
+				news[name] <- msg
+			})
+			joined := make(chan bool, 1)
+			So(client.Emit("join", rooms, func(ok bool) {
+				joined <- ok
+			}), ShouldBeNil)
+			So(<-joined, ShouldBeTrue)
+			return client
+		}
+		ab := dial("ab", "a", "b")
+		defer ab.Close()
+		b := dial("b", "b")
+		defer b.Close()
+		bc := dial("bc", "b", "c")
+		defer bc.Close()
+		none := dial("none")
+		defer none.Close()
+
+		// got returns the news of each client, after waiting for late or duplicated ones.
+		got := func() map[string][]string {
+			time.Sleep(100 * time.Millisecond)
+			ret := make(map[string][]string)
+			for name, c := range news {
+				for len(c) > 0 {
+					ret[name] = append(ret[name], <-c)
+				}
+			}
+			return ret
+		}
+
+		Convey("each socket once", func() {
+			So(server.To("a").To("b").Except("c").Emit("news", "hello"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"ab": {"hello"},
+				"b":  {"hello"},
+			})
+		})
+
+		Convey("to the namespace", func() {
+			So(server.Except("b").Emit("news", "hello"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"none": {"hello"},
+			})
+		})
+
+		Convey("from socket", func() {
+			So(ab.Emit("shout", "hi"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"b":    {"hi"},
+				"bc":   {"hi"},
+				"none": {"hi"},
+			})
+
+			So(b.Emit("whisper", "b", "psst"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"ab": {"psst"},
+				"bc": {"psst"},
+			})
+		})
+
+		Convey("join and leave rooms", func() {
+			So(server.In("c").Except("a").SocketsJoin("d"), ShouldBeNil)
+			So(server.To("d").Emit("news", "hello"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"bc": {"hello"},
+			})
+
+			So(server.In("b").SocketsLeave("b", "c"), ShouldBeNil)
+			So(server.To("b").To("c").Emit("news", "hello"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{})
+		})
+
+		Convey("where data matches", func() {
+			set := make(chan bool, 1)
+			So(ab.Emit("set", "role", "admin", func(ok bool) {
+				set <- ok
+			}), ShouldBeNil)
+			So(<-set, ShouldBeTrue)
+			So(bc.Emit("set", "role", "user", func(ok bool) {
+				set <- ok
+			}), ShouldBeNil)
+			So(<-set, ShouldBeTrue)
+
+			So(server.To("b").Where("role", "admin").Emit("news", "hello"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"ab": {"hello"},
+			})
+			So(server.Where("role", "user").SocketsJoin("d"), ShouldBeNil)
+			So(server.To("d").Emit("news", "hello"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"bc": {"hello"},
+			})
+		})
+
+		Convey("with plain adaptor", func() {
+			n := &namespace{baseHandler: newBaseHandler("", plainAdaptor{server.namespace.broadcast})}
+			So(n.To("a").Emit("news", "hello"), ShouldBeNil)
+			So(got(), ShouldResemble, map[string][]string{
+				"ab": {"hello"},
+			})
+			So(n.To("a").Except("c").Emit("news", "hello"), ShouldNotBeNil)
+			So(n.To("a").Where("role", "admin").Emit("news", "hello"), ShouldNotBeNil)
+			So(n.To("a").SocketsJoin("d"), ShouldNotBeNil)
+		})
+	})
+}
+
+// plainAdaptor hides SendTo of the adaptor.
+type plainAdaptor struct {
+	BroadcastAdaptor
+}
+
+// joiningSocket joins a room when it gets a message.
+type joiningSocket struct {
+	Socket
+	b *broadcast
+}
+
+func (s joiningSocket) Id() string {
+	return "joining"
+}
+
+func (s joiningSocket) Emit(message string, args ...interface{}) error {
+	return s.b.Join("other", s)
+}
+
+func TestBroadcastSendTo(t *testing.T) {
+	Convey("Rooms can change while sending", t, func() {
+		b := newBroadcastDefault().(*broadcast)
+		so := joiningSocket{b: b}
+		So(b.Join("room", so), ShouldBeNil)
+		So(b.SendTo(BroadcastOptions{Rooms: []string{"room"}}, "news"), ShouldBeNil)
+		n, err := b.NumberInRoom("other")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+	})
+}
@@ -12,7 +12,11 @@ import (
 	"github.com/pschlump/socketio/engineio"
 )
 
-const Protocol = 4
+// Protocol is the socket.io protocol spoken with engine.io v3 clients, ProtocolV5 with engine.io v4 clients.
+const (
+	Protocol   = 4
+	ProtocolV5 = 5
+)
 
 type packetType int
 
@@ -90,12 +94,17 @@ func (e *encoder) Encode(v packet) error {
 	return nil
 }
 
-func (e *encoder) encodePacket(v packet) error {
+func (e *encoder) encodePacket(v packet) (err error) {
 	writer, err := e.w.NextWriter(engineio.MessageText)
 	if err != nil {
 		return err
 	}
-	defer writer.Close()
+	// The packet may be queued or dropped when the writer is closed, which is reported by Close.
+	defer func() {
+		if cerr := writer.Close(); err == nil {
+			err = cerr
+		}
+	}()
 
 	w := newTrimWriter(writer, "\n")
 	wh := newWriterHelper(w)
@@ -149,6 +158,7 @@ type decoder struct {
 	message       string
 	current       io.Reader
 	currentCloser io.Closer
+	maxBinary     int64 // maxBinary is the max bytes of the attachments of a packet, 0 for no limit.
 }
 
 func newDecoder(r frameReader) *decoder {
@@ -279,6 +289,10 @@ func (d *decoder) Decode(v *packet) error {
 		d.message = msgReader.Message()
 		d.current = msgReader
 		d.currentCloser = r
+	case _CONNECT:
+		fallthrough
+	case _ERROR:
+		fallthrough
 	case _ACK:
 		fallthrough
 	case _BINARY_ACK:
@@ -317,8 +331,35 @@ func (d *decoder) DecodeData(v *packet) error {
 	return nil
 }
 
+// DecodeRaw decodes the data of v as the raw JSON of each arg and the binary attachments, without
+// reflection. Placeholders of the attachments are left in the JSON.
+func (d *decoder) DecodeRaw(v *packet) ([]json.RawMessage, [][]byte, error) {
+	if d.current == nil {
+		return nil, nil, nil
+	}
+	defer func() {
+		d.Close()
+	}()
+	var raw []json.RawMessage
+	decoder := json.NewDecoder(d.current)
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, err
+	}
+	var binary [][]byte
+	if v.Type == _BINARY_EVENT || v.Type == _BINARY_ACK {
+		var err error
+		if binary, err = d.decodeBinary(v.attachNumber); err != nil {
+			return nil, nil, err
+		}
+		v.Type -= _BINARY_EVENT - _EVENT
+	}
+	return raw, binary, nil
+}
+
 func (d *decoder) decodeBinary(num int) ([][]byte, error) {
-	ret := make([][]byte, num)
+	// num comes from the client, so the slice only grows with the attachments which arrive.
+	var ret [][]byte
+	left := d.maxBinary
 	for i := 0; i < num; i++ {
 		d.currentCloser.Close()
 		t, r, err := d.reader.NextReader()
@@ -329,11 +370,19 @@ func (d *decoder) decodeBinary(num int) ([][]byte, error) {
 		if t == engineio.MessageText {
 			return nil, fmt.Errorf("need binary")
 		}
-		b, err := ioutil.ReadAll(r)
+		var br io.Reader = r
+		if d.maxBinary > 0 {
+			br = io.LimitReader(r, left+1)
+		}
+		b, err := ioutil.ReadAll(br)
 		if err != nil {
 			return nil, err
 		}
-		ret[i] = b
+		left -= int64(len(b))
+		if d.maxBinary > 0 && left < 0 {
+			return nil, engineio.PayloadTooLargeError
+		}
+		ret = append(ret, b)
 	}
 	return ret, nil
 }
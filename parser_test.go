@@ -172,6 +172,23 @@ func TestParser(t *testing.T) {
 		So(i3, ShouldEqual, 3)
 	})
 
+	Convey("Connect with namespace and auth", t, func() {
+		p = packet{
+			Type: _CONNECT,
+			Id:   -1,
+			NSP:  "/abc",
+			Data: map[string]interface{}{"token": "123"},
+		}
+		var auth map[string]interface{}
+		decodeData = &auth
+		output = `0/abc,{"token":"123"}`
+		message = ""
+
+		test()
+
+		So(auth["token"], ShouldEqual, "123")
+	})
+
 	Convey("Binary type with attachment", t, func() {
 		p = packet{
 			Type: _EVENT,
@@ -189,4 +206,20 @@ func TestParser(t *testing.T) {
 		So(buf.String(), ShouldEqual, "data")
 	})
 
+	Convey("Attachments over the limit", t, func() {
+		saver := &FrameSaver{}
+		err := newEncoder(saver).Encode(packet{
+			Type: _EVENT,
+			Id:   -1,
+			Data: []interface{}{"binary", &Attachment{Data: bytes.NewBufferString("data")}},
+		})
+		So(err, ShouldBeNil)
+
+		d := packet{Data: &[]interface{}{&Attachment{Data: bytes.NewBuffer(nil)}}}
+		decoder := newDecoder(saver)
+		decoder.maxBinary = 3
+		So(decoder.Decode(&d), ShouldBeNil)
+		So(decoder.DecodeData(&d), ShouldEqual, engineio.PayloadTooLargeError)
+	})
+
 }
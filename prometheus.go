@@ -0,0 +1,228 @@
+package socketio
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusMetrics is the Metrics which keeps counters and histograms of a server, and serves them
+// in the Prometheus text format, e.g.
+//
+//	metrics := socketio.NewPrometheusMetrics()
+//	server.SetMetrics(metrics)
+//	http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	families map[string]*family
+	lock     sync.Mutex
+}
+
+// family is a metric with its series by label values.
+type family struct {
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	values  map[string]float64
+	hists   map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var (
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	fanOutBuckets   = []float64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000}
+)
+
+// NewPrometheusMetrics returns the PrometheusMetrics without measurements.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	ret := &PrometheusMetrics{
+		families: make(map[string]*family),
+	}
+	ret.define("socketio_connections", "gauge", "Open connections by transport.", nil, "transport")
+	ret.define("socketio_upgrades_total", "counter", "Transport upgrades of connections.", nil, "from", "to")
+	ret.define("socketio_handshakes_rejected_total", "counter", "Handshakes refused by reason.", nil, "reason")
+	ret.define("socketio_packets_received_total", "counter", "Packets read by type.", nil, "type")
+	ret.define("socketio_received_bytes_total", "counter", "Bytes of the packets read by type.", nil, "type")
+	ret.define("socketio_packets_sent_total", "counter", "Packets written by type.", nil, "type")
+	ret.define("socketio_sent_bytes_total", "counter", "Bytes of the packets written by type.", nil, "type")
+	ret.define("socketio_ack_latency_seconds", "histogram", "Time from an emit to its ack.", durationBuckets)
+	ret.define("socketio_broadcast_sockets", "histogram", "Local sockets reached by a broadcast.", fanOutBuckets)
+	ret.define("socketio_handler_duration_seconds", "histogram", "Time in the handler by event.", durationBuckets, "event")
+	return ret
+}
+
+func (m *PrometheusMetrics) define(name, typ, help string, buckets []float64, labels ...string) {
+	m.families[name] = &family{
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]float64),
+		hists:   make(map[string]*histogram),
+	}
+}
+
+// add adds delta to the series of name with the label values.
+func (m *PrometheusMetrics) add(name string, delta float64, values ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.families[name].values[strings.Join(values, "\xff")] += delta
+}
+
+// observe puts v into the histogram of name with the label values.
+func (m *PrometheusMetrics) observe(name string, v float64, values ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f := m.families[name]
+	key := strings.Join(values, "\xff")
+	h, ok := f.hists[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(f.buckets))}
+		f.hists[key] = h
+	}
+	for i, le := range f.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (m *PrometheusMetrics) ConnectionOpened(transport string) {
+	m.add("socketio_connections", 1, transport)
+}
+
+func (m *PrometheusMetrics) ConnectionClosed(transport string) {
+	m.add("socketio_connections", -1, transport)
+}
+
+func (m *PrometheusMetrics) ConnectionUpgraded(from, to string) {
+	m.add("socketio_upgrades_total", 1, from, to)
+	m.add("socketio_connections", -1, from)
+	m.add("socketio_connections", 1, to)
+}
+
+func (m *PrometheusMetrics) HandshakeRejected(reason string) {
+	m.add("socketio_handshakes_rejected_total", 1, reason)
+}
+
+func (m *PrometheusMetrics) PacketReceived(typ string, bytes int) {
+	m.add("socketio_packets_received_total", 1, typ)
+	m.add("socketio_received_bytes_total", float64(bytes), typ)
+}
+
+func (m *PrometheusMetrics) PacketSent(typ string, bytes int) {
+	m.add("socketio_packets_sent_total", 1, typ)
+	m.add("socketio_sent_bytes_total", float64(bytes), typ)
+}
+
+func (m *PrometheusMetrics) AckReceived(latency time.Duration) {
+	m.observe("socketio_ack_latency_seconds", latency.Seconds())
+}
+
+func (m *PrometheusMetrics) Broadcasted(sockets int) {
+	m.observe("socketio_broadcast_sockets", float64(sockets))
+}
+
+func (m *PrometheusMetrics) HandlerCalled(event string, d time.Duration) {
+	m.observe("socketio_handler_duration_seconds", d.Seconds(), event)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format. They are written once rendered, so a
+// slow scraper doesn't hold up the server.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	m.render(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// render writes the metrics to bw.
+func (m *PrometheusMetrics) render(bw *bytes.Buffer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := m.families[name]
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ)
+		for _, key := range sortedKeys(f) {
+			labels := f.labelPairs(key)
+			h, ok := f.hists[key]
+			if !ok {
+				fmt.Fprintf(bw, "%s%s %s\n", name, formatLabels(labels), formatFloat(f.values[key]))
+				continue
+			}
+			for i, le := range f.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(append(labels, "le", formatFloat(le))), h.counts[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(append(labels, "le", "+Inf")), h.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, formatLabels(labels), formatFloat(h.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, formatLabels(labels), h.count)
+		}
+	}
+}
+
+func sortedKeys(f *family) []string {
+	ret := make([]string, 0, len(f.values)+len(f.hists))
+	for key := range f.values {
+		ret = append(ret, key)
+	}
+	for key := range f.hists {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// labelPairs returns the names and values of the labels of the series key.
+func (f *family) labelPairs(key string) []string {
+	if len(f.labels) == 0 {
+		return nil
+	}
+	values := strings.Split(key, "\xff")
+	ret := make([]string, 0, 2*len(f.labels)+2)
+	for i, label := range f.labels {
+		ret = append(ret, label, values[i])
+	}
+	return ret
+}
+
+func formatLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,118 @@
+package socketio
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	Convey("Write metrics in text format", t, func() {
+		m := NewPrometheusMetrics()
+		m.ConnectionOpened("polling")
+		m.ConnectionUpgraded("polling", "websocket")
+		m.HandshakeRejected("max_connection")
+		m.PacketSent("event", 20)
+		m.PacketSent("event", 22)
+		m.HandlerCalled(`say "hi"`, 30*time.Millisecond)
+
+		resp := httptest.NewRecorder()
+		m.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+		body := resp.Body.String()
+		So(resp.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+		So(body, ShouldContainSubstring, "# TYPE socketio_connections gauge\n")
+		So(body, ShouldContainSubstring, `socketio_connections{transport="polling"} 0`+"\n")
+		So(body, ShouldContainSubstring, `socketio_connections{transport="websocket"} 1`+"\n")
+		So(body, ShouldContainSubstring, `socketio_upgrades_total{from="polling",to="websocket"} 1`+"\n")
+		So(body, ShouldContainSubstring, `socketio_handshakes_rejected_total{reason="max_connection"} 1`+"\n")
+		So(body, ShouldContainSubstring, `socketio_packets_sent_total{type="event"} 2`+"\n")
+		So(body, ShouldContainSubstring, `socketio_sent_bytes_total{type="event"} 42`+"\n")
+		So(body, ShouldContainSubstring, `socketio_handler_duration_seconds_bucket{event="say \"hi\"",le="0.025"} 0`+"\n")
+		So(body, ShouldContainSubstring, `socketio_handler_duration_seconds_bucket{event="say \"hi\"",le="0.05"} 1`+"\n")
+		So(body, ShouldContainSubstring, `socketio_handler_duration_seconds_bucket{event="say \"hi\"",le="+Inf"} 1`+"\n")
+		So(body, ShouldContainSubstring, `socketio_handler_duration_seconds_count{event="say \"hi\""} 1`+"\n")
+	})
+
+	Convey("Measure while a scraper stalls", t, func() {
+		m := NewPrometheusMetrics()
+		m.PacketSent("event", 20)
+		w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+		go m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		<-w.writing
+		defer close(w.release)
+
+		done := make(chan struct{})
+		go func() {
+			m.PacketSent("event", 22)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			So("PacketSent blocked", ShouldBeEmpty)
+		}
+	})
+
+	Convey("Measure server", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		metrics := NewPrometheusMetrics()
+		server.SetMetrics(metrics)
+		server.On("echo", func(so Socket, msg string) string {
+			so.Join("room")
+			server.To("room").Emit("news", msg)
+			return msg
+		})
+		So(server.OnAny(func(event string, args []interface{}) {}), ShouldBeNil)
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		news := make(chan string, 1)
+		client.On("news", func(msg string) {
+			news <- msg
+		})
+		So(client.Emit("made up"), ShouldBeNil)
+		echo := make(chan string, 1)
+		So(client.Emit("echo", "hi", func(msg string) {
+			echo <- msg
+		}), ShouldBeNil)
+		So(<-echo, ShouldEqual, "hi")
+		So(<-news, ShouldEqual, "hi")
+
+		mh := httptest.NewServer(metrics)
+		defer mh.Close()
+		resp, err := mh.Client().Get(mh.URL)
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		body := string(b)
+		So(body, ShouldContainSubstring, `socketio_handler_duration_seconds_count{event="echo"} 1`)
+		So(body, ShouldContainSubstring, `socketio_handler_duration_seconds_count{event="*"} 2`)
+		So(body, ShouldNotContainSubstring, `event="made up"`)
+		So(body, ShouldContainSubstring, `socketio_packets_received_total{type="event"} 2`)
+		So(body, ShouldContainSubstring, `socketio_packets_sent_total{type="ack"} 1`)
+		So(body, ShouldContainSubstring, "socketio_broadcast_sockets_count 1")
+		So(strings.Count(body, "socketio_connections{"), ShouldBeGreaterThan, 0)
+	})
+}
+
+// stalledWriter blocks writes until release is closed.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	close(w.writing)
+	<-w.release
+	return w.ResponseRecorder.Write(b)
+}
@@ -0,0 +1,186 @@
+package socketio
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// recovery keeps the sockets of closed connections for a while, so socket.io v5 clients which
+// reconnect in time get their id, rooms and missed packets back. It's shared by all namespaces of
+// a server.
+type recovery struct {
+	window     time.Duration
+	maxPackets int
+	sessions   map[string]*session
+	lock       sync.Mutex
+}
+
+// session is a socket waiting for its client to reconnect. The socket stays in its rooms and keeps
+// the packets sent to it, until the timer removes it.
+type session struct {
+	socket *socket
+	timer  *time.Timer
+}
+
+// sentPacket is an event sent with an offset, kept to be sent again on recovery.
+type sentPacket struct {
+	offset int64
+	args   []interface{}
+}
+
+func newRecovery() *recovery {
+	return &recovery{
+		sessions: make(map[string]*session),
+	}
+}
+
+func (r *recovery) set(window time.Duration, maxPackets int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if maxPackets < 0 {
+		maxPackets = 0
+	}
+	r.window = window
+	r.maxPackets = maxPackets
+}
+
+func (r *recovery) enabled() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.window > 0
+}
+
+// limit returns the number of packets kept for each socket.
+func (r *recovery) limit() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.maxPackets
+}
+
+// keep keeps the socket s of a closed connection for the recovery window. It returns false if s
+// can't be recovered.
+func (r *recovery) keep(s *socket) bool {
+	if s.pid == "" {
+		return false
+	}
+	r.lock.Lock()
+	if r.window <= 0 {
+		r.lock.Unlock()
+		return false
+	}
+	pid := s.pid
+	r.sessions[pid] = &session{
+		socket: s,
+		timer: time.AfterFunc(r.window, func() {
+			r.lock.Lock()
+			sess, ok := r.sessions[pid]
+			ok = ok && sess.socket == s
+			if ok {
+				delete(r.sessions, pid)
+			}
+			r.lock.Unlock()
+			if ok {
+				s.LeaveAll()
+			}
+		}),
+	}
+	r.lock.Unlock()
+
+	// the disconnect handler may emit, which needs r.lock
+	s.detach()
+	return true
+}
+
+// take removes the session of the private id pid in namespace nsp and returns its socket, or nil if
+// there is none.
+func (r *recovery) take(pid, nsp string) *socket {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	sess, ok := r.sessions[pid]
+	if !ok || sess.socket.namespace != nsp {
+		return nil
+	}
+	if !sess.timer.Stop() {
+		return nil
+	}
+	delete(r.sessions, pid)
+	return sess.socket
+}
+
+// restore makes the new socket s take the place of old, with its data, whose client says it got
+// the packets up to offset. It returns the rooms of old, which s joins with takeOver once the client
+// has its CONNECT, or false if some of the missed packets aren't kept anymore, then s is a new socket
+// and old leaves its rooms.
+func (s *socket) restore(old *socket, offset string) ([]string, bool) {
+	old.recoveryLock.Lock()
+	last, sent := old.offset, old.sent
+	old.recoveryLock.Unlock()
+	n, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		n = 0
+	}
+	i := 0
+	for i < len(sent) && sent[i].offset <= n {
+		i++
+	}
+	if n > last || (i == 0 && len(sent) > 0 && sent[0].offset > n+1) {
+		old.LeaveAll()
+		return nil, false
+	}
+
+	old.lock.RLock()
+	rooms := make([]string, 0, len(old.rooms))
+	for room := range old.rooms {
+		rooms = append(rooms, room)
+	}
+	old.lock.RUnlock()
+
+	s.sid = old.sid
+	s.pid = old.pid
+	s.offset = n
+	s.recovered = true
+	s.data.copyFrom(&old.data)
+	return rooms, true
+}
+
+// takeOver moves rooms from old to s, then sends the packets old kept after the offset of the client.
+// s has the id of old, so joining a room replaces old in it at once, and no broadcast is lost in
+// between. Packets sent to s meanwhile wait for the missed ones.
+func (s *socket) takeOver(old *socket, rooms []string) error {
+	s.recoveryLock.Lock()
+	defer s.recoveryLock.Unlock()
+	for _, room := range rooms {
+		if err := s.Join(room); err != nil {
+			return err
+		}
+	}
+	old.lock.Lock()
+	old.rooms = make(map[string]struct{})
+	old.lock.Unlock()
+
+	old.recoveryLock.Lock()
+	last, sent := old.offset, old.sent
+	old.recoveryLock.Unlock()
+	i := 0
+	for i < len(sent) && sent[i].offset <= s.offset {
+		i++
+	}
+	s.offset = last
+	s.sent = sent
+	for _, m := range sent[i:] {
+		if err := s.conn.encode(packet{Type: _EVENT, Id: -1, NSP: s.namespace, Data: m.args}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newPid returns a random private session id, which only the client of the socket knows.
+func newPid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
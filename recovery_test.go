@@ -0,0 +1,132 @@
+package socketio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecovery(t *testing.T) {
+	Convey("Recover socket of socket.io v5 client", t, func() {
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		server.SetConnectionStateRecovery(time.Second, 10)
+		type connection struct {
+			id        string
+			recovered bool
+		}
+		connections := make(chan connection, 2)
+		server.On("connection", func(so Socket) {
+			if !so.Recovered() {
+				so.Join("room")
+			}
+			connections <- connection{so.Id(), so.Recovered()}
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		poll := func(method, query, body string) string {
+			req, err := http.NewRequest(method, h.URL+"/socket.io/?EIO=4&transport=polling"+query, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			b, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return string(b)
+		}
+		// connect opens a session and connects the root namespace with auth, then returns the sid,
+		// the data of the CONNECT packet and the packets which came with it.
+		connect := func(auth string) (string, map[string]string, []string) {
+			var info struct {
+				Sid string `json:"sid"`
+			}
+			open := poll("GET", "", "")
+			So(json.Unmarshal([]byte(open[1:]), &info), ShouldBeNil)
+			So(poll("POST", "&sid="+info.Sid, "40"+auth), ShouldEqual, "ok")
+			records := strings.Split(poll("GET", "&sid="+info.Sid, ""), "\x1e")
+			So(records[0], ShouldStartWith, "40")
+			var data map[string]string
+			So(json.Unmarshal([]byte(records[0][2:]), &data), ShouldBeNil)
+			return info.Sid, data, records[1:]
+		}
+
+		sid, data, _ := connect("")
+		c := <-connections
+		So(c.recovered, ShouldBeFalse)
+		So(data["sid"], ShouldEqual, c.id)
+		So(data["pid"], ShouldNotEqual, "")
+
+		So(server.To("room").Emit("news", "one"), ShouldBeNil)
+		So(poll("GET", "&sid="+sid, ""), ShouldEqual, `42["news","one","1"]`)
+
+		// the transport closes without DISCONNECT
+		So(poll("POST", "&sid="+sid, "1"), ShouldEqual, "ok")
+		for server.Socket(c.id) != nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(server.To("room").Emit("news", "two"), ShouldBeNil)
+		So(server.To("room").Emit("news", "three"), ShouldBeNil)
+
+		Convey("with missed packets", func() {
+			sid, recovered, missed := connect(`{"pid":"` + data["pid"] + `","offset":"1"}`)
+			for len(missed) < 2 {
+				missed = append(missed, strings.Split(poll("GET", "&sid="+sid, ""), "\x1e")...)
+			}
+			So(missed, ShouldResemble, []string{`42["news","two","2"]`, `42["news","three","3"]`})
+			So(sid, ShouldNotEqual, c.id)
+			So(recovered["sid"], ShouldEqual, c.id)
+			So(recovered["pid"], ShouldEqual, data["pid"])
+			c := <-connections
+			So(c.recovered, ShouldBeTrue)
+			So(c.id, ShouldEqual, recovered["sid"])
+
+			members, err := server.SocketsInRoom("room")
+			So(err, ShouldBeNil)
+			So(members, ShouldResemble, []string{c.id})
+
+			// the recovered socket goes on with the offsets of the old one
+			So(server.To("room").Emit("news", "four"), ShouldBeNil)
+			So(poll("GET", "&sid="+sid, ""), ShouldEqual, `42["news","four","4"]`)
+		})
+
+		Convey("not with unknown pid", func() {
+			_, fresh, _ := connect(`{"pid":"unknown","offset":"1"}`)
+			So(fresh["sid"], ShouldNotEqual, c.id)
+			So(fresh["pid"], ShouldNotEqual, data["pid"])
+			So((<-connections).recovered, ShouldBeFalse)
+		})
+
+		Convey("not after the window", func() {
+			time.Sleep(1100 * time.Millisecond)
+			members, err := server.SocketsInRoom("room")
+			So(err, ShouldBeNil)
+			So(len(members), ShouldEqual, 0)
+			_, fresh, _ := connect(`{"pid":"` + data["pid"] + `","offset":"1"}`)
+			So(fresh["sid"], ShouldNotEqual, c.id)
+			So((<-connections).recovered, ShouldBeFalse)
+		})
+	})
+}
+
+func TestRecoveryLimit(t *testing.T) {
+	Convey("Negative max packets keep no packet", t, func() {
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		server.SetConnectionStateRecovery(time.Second, -1)
+		So(server.namespace.recovery.limit(), ShouldEqual, 0)
+
+		so := &socket{nsp: server.namespace, pid: newPid(), detached: true}
+		So(so.send([]interface{}{"news", "one"}), ShouldBeNil)
+		So(len(so.sent), ShouldEqual, 0)
+		So(so.offset, ShouldEqual, 1)
+	})
+}
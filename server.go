@@ -1,10 +1,13 @@
 package socketio
 
 import (
+	"context"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/pschlump/socketio/engineio"
+	"github.com/pschlump/socketio/engineio/websocket"
 )
 
 // Server is the server of socket.io.
@@ -12,6 +15,11 @@ type Server struct {
 	*namespace
 	broadcast BroadcastAdaptor
 	eio       *engineio.Server
+	conns     map[*conn]struct{}
+	closed    bool
+	admin     *admin
+	lock      sync.Mutex
+	wg        sync.WaitGroup
 }
 
 // NewServer returns the server supported given transports. If transports is nil, server will use ["polling", "websocket"] as default.
@@ -23,6 +31,7 @@ func NewServer(transportNames []string) (*Server, error) {
 	ret := &Server{
 		namespace: newNamespace(newBroadcastDefault()),
 		eio:       eio,
+		conns:     make(map[*conn]struct{}),
 	}
 	go ret.loop()
 	return ret, nil
@@ -63,14 +72,83 @@ func (s *Server) SetNewId(f func(*http.Request) string) {
 	s.eio.SetNewId(f)
 }
 
+// SetWebsocketOptions configures the websocket transport, e.g. the origins which may connect, buffer
+// sizes and compression.
+func (s *Server) SetWebsocketOptions(opts websocket.Options) {
+	s.eio.SetWebsocketOptions(opts)
+}
+
+// SetMaxHTTPBufferSize sets the max bytes a client may send in one polling request, websocket message
+// or the attachments of a packet, 0 for no limit. The connection of a client which sends more is
+// closed. Default is 1e6.
+func (s *Server) SetMaxHTTPBufferSize(n int64) {
+	s.eio.SetMaxHTTPBufferSize(n)
+}
+
+// SetOutboundQueue sets the max packets queued for a client which reads them slower than they are
+// sent, 0 for no limit, and what is done when a packet is sent to a full queue. Emits and broadcasts
+// only queue packets, so a slow client doesn't hold up the others. Default is 10000 and Disconnect.
+func (s *Server) SetOutboundQueue(size int, policy engineio.QueuePolicy) {
+	s.eio.SetOutboundQueue(size, policy)
+}
+
+// SetCORS sets the origins which browsers may send long-polling requests from, e.g. when the page is
+// served from a CDN. Default allows no other origin.
+func (s *Server) SetCORS(cors engineio.CORS) {
+	s.eio.SetCORS(cors)
+}
+
 // SetSessionsManager sets the sessions as server's session manager. Default sessions is single process manager. You can custom it as load balance.
 func (s *Server) SetSessionManager(sessions engineio.Sessions) {
 	s.eio.SetSessionManager(sessions)
 }
 
+// SetSessionRegistry sets the registry of session owners, and node as the base url of this server, e.g.
+// "http://10.0.0.1:8000". Requests of sessions owned by other nodes are forwarded to them.
+func (s *Server) SetSessionRegistry(registry engineio.SessionRegistry, node string) {
+	s.eio.SetSessionRegistry(registry, node)
+}
+
+// SetConnectionStateRecovery keeps the sockets of socket.io v5 clients for window after their connection
+// is lost. A client reconnecting in time gets the same socket id and rooms, and the last maxPackets
+// events sent to the socket which it missed, none if maxPackets isn't positive. Default window is 0,
+// which disables recovery.
+func (s *Server) SetConnectionStateRecovery(window time.Duration, maxPackets int) {
+	s.namespace.recovery.set(window, maxPackets)
+}
+
 // SetAdaptor sets the adaptor of broadcast. Default is in-process broadcast implement.
 func (s *Server) SetAdaptor(adaptor BroadcastAdaptor) {
-	s.namespace = newNamespace(adaptor)
+	nsp := newNamespace(adaptor)
+	nsp.recovery = s.namespace.recovery
+	nsp.logger = s.namespace.logger
+	nsp.meter = s.namespace.meter
+	nsp.watch = s.namespace.watch
+	if m, ok := adaptor.(metricsSetter); ok {
+		m.SetMetrics(nsp.meter.get())
+	}
+	s.namespace = nsp
+}
+
+// SetMetrics sets the receiver of the measurements of connections, packets, acks, broadcasts and
+// handlers, e.g. a PrometheusMetrics. An adaptor with a SetMetrics(Metrics) method gets it too, to
+// report its broadcasts. Default drops them.
+func (s *Server) SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	s.eio.SetMetrics(m)
+	s.namespace.meter.set(m)
+	if a, ok := s.namespace.broadcast.(metricsSetter); ok {
+		a.SetMetrics(m)
+	}
+}
+
+// SetLogger sets the logger of the server, which gets records with the sid, namespace and event
+// of packets, connections and errors, and the errors of the session registry. Default logs nothing.
+func (s *Server) SetLogger(logger Logger) {
+	s.eio.SetLogger(logger)
+	s.namespace.logger.set(logger)
 }
 
 // ServeHTTP handles http request.
@@ -83,15 +161,101 @@ func (s *Server) BroadcastTo(room, message string, args ...interface{}) {
 	s.namespace.BroadcastTo(room, message, args...)
 }
 
+// Socket returns the connected socket with id in any namespace, preferring the root one, or nil.
+func (s *Server) Socket(id string) Socket {
+	if so := s.socket(id); so != nil {
+		return so
+	}
+	return nil
+}
+
+// Sockets returns the connected sockets of every namespace. A client in several namespaces has a
+// socket with the same id in each.
+func (s *Server) Sockets() []Socket {
+	var ret []Socket
+	for _, nsp := range s.all() {
+		ret = append(ret, nsp.Sockets()...)
+	}
+	return ret
+}
+
+// DisconnectSocket disconnects the client with id from every namespace and closes its connection.
+func (s *Server) DisconnectSocket(id string) error {
+	so := s.socket(id)
+	if so == nil {
+		return NoSocketError
+	}
+	so.conn.disconnectAll()
+	return nil
+}
+
+// socket returns the socket with id in the root namespace, else in any other.
+func (s *Server) socket(id string) *socket {
+	for _, nsp := range append([]*namespace{s.namespace}, s.all()...) {
+		nsp.lock.RLock()
+		so := nsp.sockets[id]
+		nsp.lock.RUnlock()
+		if so != nil {
+			return so
+		}
+	}
+	return nil
+}
+
 func (s *Server) loop() {
 	for {
 		conn, err := s.eio.Accept()
 		if err != nil {
 			return
 		}
-		s := newSocket(conn, s.baseHandler)
-		go func(s *socket) {
-			s.loop()
-		}(s)
+		c := newConn(conn, s.namespace)
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.loop()
+			s.lock.Lock()
+			delete(s.conns, c)
+			s.lock.Unlock()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections, disconnects every socket and closes the connections, then
+// waits for the handlers to return. It returns the error of ctx if ctx is done before.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.eio.Close()
+	s.lock.Lock()
+	s.closed = true
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	if s.admin != nil {
+		s.admin.stop()
+		s.admin = nil
+	}
+	s.lock.Unlock()
+
+	for _, c := range conns {
+		go c.disconnectAll()
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
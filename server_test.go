@@ -0,0 +1,62 @@
+package socketio
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServerShutdown(t *testing.T) {
+	Convey("Shutdown server", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		events := make(chan string, 10)
+		wait := make(chan bool)
+		server.On("connection", func(so Socket) {
+			so.On("disconnect", func() {
+				events <- "server disconnect"
+				<-wait
+			})
+			events <- "connection"
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		client.On("disconnect", func() {
+			events <- "client disconnect"
+		})
+		So(<-events, ShouldEqual, "connection")
+
+		Convey("waits for handlers", func() {
+			done := make(chan error, 1)
+			go func() {
+				done <- server.Shutdown(context.Background())
+			}()
+			So(<-events, ShouldEqual, "server disconnect")
+			select {
+			case <-done:
+				So("shutdown before handler returned", ShouldBeEmpty)
+			case <-time.After(100 * time.Millisecond):
+			}
+			close(wait)
+			So(<-done, ShouldBeNil)
+			So(<-events, ShouldEqual, "client disconnect")
+
+			_, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("until context is done", func() {
+			defer close(wait)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			So(server.Shutdown(ctx), ShouldResemble, context.DeadlineExceeded)
+		})
+	})
+}
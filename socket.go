@@ -1,9 +1,14 @@
 package socketio
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/pschlump/socketio/engineio"
+	"github.com/pschlump/json" //	"encoding/json"
 )
 
 // Socket is the socket object of socket.io.
@@ -12,56 +17,172 @@ type Socket interface {
 	Rooms() []string                                             // Rooms returns the rooms name joined now.
 	Request() *http.Request                                      // Request returns the first http request when established connection.
 	On(message string, f interface{}) error                      // On registers the function f to handle message.
-	OnAny(f interface{}) error                                   // Register a function that will get called on any message
+	OnAny(f interface{}) error                                   // OnAny registers the function f, which takes the message name first, to handle every message.
+	Handle(message string, f EventHandlerFunc) error             // Handle registers the raw handler f to handle message.
+	HandleAny(f EventHandlerFunc) error                          // HandleAny registers the raw handler f to handle every message.
 	Emit(message string, args ...interface{}) error              // Emit emits the message with given args.
 	Join(room string) error                                      // Join joins the room.
 	Leave(room string) error                                     // Leave leaves the room.
 	BroadcastTo(room, message string, args ...interface{}) error // BroadcastTo broadcasts the message to the room with given args.
+	Auth() map[string]interface{}                                // Auth returns the auth payload sent with CONNECT, only socket.io v3+ clients send it.
+	To(room string) *BroadcastOperator                           // To returns the operator broadcasting to the room but this socket.
+	In(room string) *BroadcastOperator                           // In is the same as To.
+	Except(room string) *BroadcastOperator                       // Except returns the operator broadcasting to the namespace but this socket and the room.
+	Where(key string, val interface{}) *BroadcastOperator        // Where returns the operator broadcasting to the sockets whose value of key is val but this socket.
+	Broadcast() *BroadcastOperator                               // Broadcast returns the operator broadcasting to the namespace but this socket.
+	Disconnect() error                                           // Disconnect disconnects the socket from its namespace, closing the connection if it has no other namespace.
+	Recovered() bool                                             // Recovered returns true if the socket took the place of one whose connection was lost, see Server.SetConnectionStateRecovery.
+	Data() map[string]interface{}                                // Data returns a copy of the values set on the socket.
+	Set(key string, val interface{})                             // Set sets the value of key, which adaptors may send to other servers as JSON.
+	Get(key string) interface{}                                  // Get returns the value of key, or nil if it isn't set.
+
+	// EmitWithAck emits the message with given args and waits for the ack until ctx is done.
+	EmitWithAck(ctx context.Context, message string, args ...interface{}) ([]json.RawMessage, error)
+
+	// Timeout returns an emitter whose acks are dropped after d.
+	Timeout(d time.Duration) Emitter
+
+	// Volatile returns an emitter which drops messages the client can't get at once, e.g. while the
+	// connection upgrades or earlier messages are still waiting. Dropped messages aren't recovered.
+	Volatile() Emitter
 }
 
+// Emitter emits messages with acks which time out, see Socket.Timeout.
+type Emitter interface {
+
+	// Emit emits the message with given args. If the last arg is an ack func whose first arg is an error,
+	// it gets nil with the ack, or context.DeadlineExceeded if the ack doesn't arrive in time.
+	Emit(message string, args ...interface{}) error
+
+	// EmitWithAck emits the message with given args and waits for the ack until ctx is done or time out.
+	EmitWithAck(ctx context.Context, message string, args ...interface{}) ([]json.RawMessage, error)
+}
+
+// DroppedError is returned by the emitter of Socket.Volatile when a message with an ack is dropped. An
+// ack func which takes an error first gets it instead.
+var DroppedError = errors.New("volatile message dropped")
+
+// DisconnectedError is returned to acks which are still waiting when the socket disconnects.
+var DisconnectedError = errors.New("socket disconnected")
+
 type socket struct {
 	*socketHandler
-	conn      engineio.Conn
+	conn      *conn
+	nsp       *namespace
 	namespace string
+	sid       string
 	id        int
+	auth      map[string]interface{}
+	data      store
+	issued    time.Time
+
+	// With connection state recovery, pid is the private id the client reconnects with, and events
+	// get an offset as last arg. The last ones are kept in sent, up to the limit of the recovery, to be
+	// sent again if the client misses them.
+	pid          string
+	offset       int64
+	sent         []sentPacket
+	detached     bool
+	recovered    bool
+	recoveryLock sync.Mutex
 }
 
-func newSocket(conn engineio.Conn, base *baseHandler) *socket {
+func newSocket(c *conn, nsp *namespace) *socket {
 	// fmt.Printf("This Socket\n")
 	ret := &socket{
-		conn: conn,
+		conn:      c,
+		nsp:       nsp,
+		namespace: nsp.Name(),
+		sid:       c.Id(),
+		issued:    time.Now(),
 	}
-	ret.socketHandler = newSocketHandler(ret, base)
+	ret.socketHandler = newSocketHandler(ret, nsp.baseHandler)
 	return ret
 }
 
 func (s *socket) Id() string {
-	return s.conn.Id()
+	return s.sid
 }
 
 func (s *socket) Request() *http.Request {
 	return s.conn.Request()
 }
 
+func (s *socket) Auth() map[string]interface{} {
+	return s.auth
+}
+
+func (s *socket) Timeout(d time.Duration) Emitter {
+	return &timeoutEmitter{
+		socket:  s,
+		timeout: d,
+	}
+}
+
+func (s *socket) Volatile() Emitter {
+	return &volatileEmitter{
+		socket: s,
+	}
+}
+
 func (s *socket) Emit(message string, args ...interface{}) error {
 	if err := s.socketHandler.Emit(message, args...); err != nil {
 		return err
 	}
 	if message == "disconnect" {
-		s.conn.Close()
+		s.conn.close()
 	}
 	return nil
 }
 
+func (s *socket) log() Logger {
+	return s.nsp.logger.get()
+}
+
+func (s *socket) metrics() Metrics {
+	return s.nsp.meter.get()
+}
+
+func (s *socket) Recovered() bool {
+	return s.recovered
+}
+
+func (s *socket) Disconnect() error {
+	return s.conn.disconnect(s)
+}
+
 func (s *socket) send(args []interface{}) error {
+	if s.pid != "" {
+		s.recoveryLock.Lock()
+		defer s.recoveryLock.Unlock()
+		s.offset++
+		args = append(args[:len(args):len(args)], strconv.FormatInt(s.offset, 10))
+		s.sent = append(s.sent, sentPacket{offset: s.offset, args: args})
+		if max := s.nsp.recovery.limit(); len(s.sent) > max {
+			s.sent = append([]sentPacket(nil), s.sent[len(s.sent)-max:]...)
+		}
+		if s.detached {
+			return nil
+		}
+	}
 	packet := packet{
 		Type: _EVENT,
 		Id:   -1,
 		NSP:  s.namespace,
 		Data: args,
 	}
-	encoder := newEncoder(s.conn)
-	return encoder.Encode(packet)
+	return s.conn.encode(packet)
+}
+
+// sendVolatile sends args without an offset, so they aren't kept for recovery. It returns DroppedError
+// if they can't reach the client at once.
+func (s *socket) sendVolatile(args []interface{}) error {
+	return s.conn.encodeVolatile(packet{
+		Type: _EVENT,
+		Id:   -1,
+		NSP:  s.namespace,
+		Data: args,
+	})
 }
 
 func (s *socket) sendConnect() error {
@@ -70,11 +191,17 @@ func (s *socket) sendConnect() error {
 		Id:   -1,
 		NSP:  s.namespace,
 	}
-	encoder := newEncoder(s.conn)
-	return encoder.Encode(packet)
+	if s.conn.protocol == ProtocolV5 {
+		data := map[string]interface{}{"sid": s.Id()}
+		if s.pid != "" {
+			data["pid"] = s.pid
+		}
+		packet.Data = data
+	}
+	return s.conn.encode(packet)
 }
 
-func (s *socket) sendId(args []interface{}) (int, error) {
+func (s *socket) sendId(args []interface{}, volatile bool) (int, error) {
 	packet := packet{
 		Type: _EVENT,
 		Id:   s.id,
@@ -85,64 +212,90 @@ func (s *socket) sendId(args []interface{}) (int, error) {
 	if s.id < 0 {
 		s.id = 0
 	}
-	encoder := newEncoder(s.conn)
-	err := encoder.Encode(packet)
+	encode := s.conn.encode
+	if volatile {
+		encode = s.conn.encodeVolatile
+	}
+	err := encode(packet)
 	if err != nil {
-		return -1, nil
+		return -1, err
 	}
 	return packet.Id, nil
 }
 
-func (s *socket) loop() error {
-	defer func() {
-		s.LeaveAll()
-		p := packet{
-			Type: _DISCONNECT,
-			Id:   -1,
+// onPacket handles the packet p of the namespace, and sends the ack if p asks for one.
+func (s *socket) onPacket(decoder *decoder, p *packet) error {
+	ret, err := s.socketHandler.onPacket(decoder, p)
+	if err != nil {
+		return err
+	}
+	switch p.Type {
+	case _BINARY_EVENT:
+		fallthrough
+	case _EVENT:
+		if p.Id >= 0 {
+			return s.conn.encode(packet{
+				Type: _ACK,
+				Id:   p.Id,
+				NSP:  s.namespace,
+				Data: ret,
+			})
 		}
-		s.socketHandler.onPacket(nil, &p)
-	}()
+	}
+	return nil
+}
+
+// onDisconnect removes the socket from its namespace and rooms, and calls the disconnect handler.
+func (s *socket) onDisconnect() {
+	s.LeaveAll()
+	s.close()
+}
+
+// detach removes the socket from its namespace and calls the disconnect handler, but leaves it in
+// its rooms to keep the packets for recovery.
+func (s *socket) detach() {
+	s.recoveryLock.Lock()
+	s.detached = true
+	s.recoveryLock.Unlock()
+	s.close()
+}
 
+func (s *socket) close() {
+	s.log().Info("socket disconnected", "sid", s.Id(), "nsp", s.namespace)
+	s.nsp.remove(s)
+	s.nsp.watch.get().socketDisconnected(s)
+	s.cancelAcks(DisconnectedError)
 	p := packet{
-		Type: _CONNECT,
+		Type: _DISCONNECT,
 		Id:   -1,
-	}
-	encoder := newEncoder(s.conn)
-	if err := encoder.Encode(p); err != nil {
-		return err
+		NSP:  s.namespace,
 	}
 	s.socketHandler.onPacket(nil, &p)
-	for {
-		decoder := newDecoder(s.conn)
-		var p packet
-		if err := decoder.Decode(&p); err != nil {
-			return err
-		}
-		ret, err := s.socketHandler.onPacket(decoder, &p)
-		if err != nil {
-			return err
-		}
-		switch p.Type {
-		case _CONNECT:
-			s.namespace = p.NSP
-			s.sendConnect()
-		case _BINARY_EVENT:
-			fallthrough
-		case _EVENT:
-			if p.Id >= 0 {
-				p := packet{
-					Type: _ACK,
-					Id:   p.Id,
-					NSP:  s.namespace,
-					Data: ret,
-				}
-				encoder := newEncoder(s.conn)
-				if err := encoder.Encode(p); err != nil {
-					return err
-				}
-			}
-		case _DISCONNECT:
-			return nil
-		}
-	}
+}
+
+type timeoutEmitter struct {
+	socket  *socket
+	timeout time.Duration
+}
+
+func (e *timeoutEmitter) Emit(message string, args ...interface{}) error {
+	return e.socket.emit(e.timeout, false, message, args...)
+}
+
+func (e *timeoutEmitter) EmitWithAck(ctx context.Context, message string, args ...interface{}) ([]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	return e.socket.EmitWithAck(ctx, message, args...)
+}
+
+type volatileEmitter struct {
+	socket *socket
+}
+
+func (e *volatileEmitter) Emit(message string, args ...interface{}) error {
+	return e.socket.emit(0, true, message, args...)
+}
+
+func (e *volatileEmitter) EmitWithAck(ctx context.Context, message string, args ...interface{}) ([]json.RawMessage, error) {
+	return e.socket.emitWithAck(ctx, true, message, args...)
 }
@@ -0,0 +1,293 @@
+package socketio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschlump/json" //	"encoding/json"
+	"github.com/pschlump/socketio/engineio"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSocketV5(t *testing.T) {
+	Convey("Connect by socket.io v5 over polling", t, func() {
+		server, err := NewServer([]string{"polling"})
+		So(err, ShouldBeNil)
+		auth := make(chan map[string]interface{}, 1)
+		server.On("connection", func(so Socket) {
+			auth <- so.Auth()
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		poll := func(method, query, body string) string {
+			req, err := http.NewRequest(method, h.URL+"/socket.io/?EIO=4&transport=polling"+query, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			b, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return string(b)
+		}
+
+		open := poll("GET", "", "")
+		So(open, ShouldStartWith, "0")
+		var info struct {
+			Sid        string `json:"sid"`
+			MaxPayload int64  `json:"maxPayload"`
+		}
+		So(json.Unmarshal([]byte(open[1:]), &info), ShouldBeNil)
+		So(info.Sid, ShouldNotEqual, "")
+		So(info.MaxPayload, ShouldBeGreaterThan, 0)
+
+		So(poll("POST", "&sid="+info.Sid, `40{"token":"abc"}`), ShouldEqual, "ok")
+		So((<-auth)["token"], ShouldEqual, "abc")
+
+		records := strings.Split(poll("GET", "&sid="+info.Sid, ""), "\x1e")
+		So(records[0], ShouldEqual, `40{"sid":"`+info.Sid+`"}`)
+	})
+}
+
+func TestSocketAck(t *testing.T) {
+	Convey("Emit to client with acks", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		sockets := make(chan Socket, 1)
+		server.On("connection", func(so Socket) {
+			sockets <- so
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		client.On("echo", func(msg string) string {
+			return msg + "!"
+		})
+		client.On("slow", func(msg string) string {
+			time.Sleep(200 * time.Millisecond)
+			return msg
+		})
+		so := <-sockets
+
+		Convey("wait for ack", func() {
+			ret, err := so.EmitWithAck(context.Background(), "echo", "hi")
+			So(err, ShouldBeNil)
+			So(len(ret), ShouldEqual, 1)
+			So(string(ret[0]), ShouldEqual, `"hi!"`)
+		})
+
+		Convey("ack func with timeout", func() {
+			ack := make(chan error, 1)
+			err := so.Timeout(time.Second).Emit("echo", "hi", func(err error, msg string) {
+				if msg != "hi!" {
+					err = fmt.Errorf("bad ack %q", msg)
+				}
+				ack <- err
+			})
+			So(err, ShouldBeNil)
+			So(<-ack, ShouldBeNil)
+		})
+
+		Convey("ack timed out", func() {
+			ack := make(chan error, 1)
+			err := so.Timeout(50*time.Millisecond).Emit("slow", "hi", func(err error, msg string) {
+				ack <- err
+			})
+			So(err, ShouldBeNil)
+			So(<-ack, ShouldResemble, context.DeadlineExceeded)
+			So(len(so.(*socket).acks), ShouldEqual, 0)
+
+			_, err = so.Timeout(50*time.Millisecond).EmitWithAck(context.Background(), "slow", "hi")
+			So(err, ShouldResemble, context.DeadlineExceeded)
+			So(len(so.(*socket).acks), ShouldEqual, 0)
+		})
+
+		Convey("ack canceled by disconnect", func() {
+			ret := make(chan error, 1)
+			go func() {
+				_, err := so.EmitWithAck(context.Background(), "slow", "hi")
+				ret <- err
+			}()
+			time.Sleep(50 * time.Millisecond)
+			client.Close()
+			So(<-ret, ShouldEqual, DisconnectedError)
+		})
+	})
+}
+
+func TestSocketHandle(t *testing.T) {
+	Convey("Raw handlers", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		type event struct {
+			message string
+			args    []string
+		}
+		raw := make(chan event, 10)
+		any := make(chan string, 10)
+		typed := make(chan string, 10)
+		server.Handle("raw", func(so Socket, message string, args [][]byte) error {
+			e := event{message: message}
+			for _, arg := range args {
+				e.args = append(e.args, string(arg))
+			}
+			raw <- e
+			return nil
+		})
+		server.HandleAny(func(so Socket, message string, args [][]byte) error {
+			any <- message
+			return nil
+		})
+		server.On("typed", func(msg string, a *Attachment) {
+			b, _ := ioutil.ReadAll(a.Data)
+			typed <- msg + string(b)
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		Convey("get raw json", func() {
+			So(client.Emit("raw", "hi", map[string]int{"a": 1}), ShouldBeNil)
+			e := <-raw
+			So(e.message, ShouldEqual, "raw")
+			So(e.args, ShouldResemble, []string{`"hi"`, `{"a":1}`})
+			So(<-any, ShouldEqual, "raw")
+		})
+
+		Convey("get binary attachments", func() {
+			So(client.Emit("raw", &Attachment{Data: bytes.NewBufferString("bin")}), ShouldBeNil)
+			e := <-raw
+			So(e.args, ShouldResemble, []string{`{"_placeholder":true,"num":0}`, "bin"})
+		})
+
+		Convey("typed handler after any", func() {
+			So(client.Emit("typed", "hi ", &Attachment{Data: bytes.NewBufferString("bin")}), ShouldBeNil)
+			So(<-any, ShouldEqual, "typed")
+			So(<-typed, ShouldEqual, "hi bin")
+		})
+	})
+}
+
+func TestSocketOnAny(t *testing.T) {
+	Convey("Any handlers", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		So(server.OnAny(func(so Socket, n int) {}), ShouldNotBeNil)
+
+		all := make(chan []interface{}, 10)
+		typed := make(chan string, 10)
+		So(server.OnAny(func(so Socket, message string, args []interface{}) int {
+			all <- append([]interface{}{message}, args...)
+			return len(args)
+		}), ShouldBeNil)
+		So(server.OnAny(func(message string, msg string) {
+			typed <- message + ":" + msg
+		}), ShouldBeNil)
+		known := make(chan string, 10)
+		server.On("known", func(msg string) string {
+			known <- msg
+			return msg + "!"
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		Convey("before the handler", func() {
+			ack := make(chan string, 1)
+			So(client.Emit("known", "a", func(msg string) {
+				ack <- msg
+			}), ShouldBeNil)
+			So(<-all, ShouldResemble, []interface{}{"known", "a"})
+			So(<-typed, ShouldEqual, "known:a")
+			So(<-known, ShouldEqual, "a")
+			So(<-ack, ShouldEqual, "a!")
+		})
+
+		Convey("instead of the handler", func() {
+			ack := make(chan int, 1)
+			So(client.Emit("unknown", "b", 1, func(n int) {
+				ack <- n
+			}), ShouldBeNil)
+			So(<-all, ShouldResemble, []interface{}{"unknown", "b", float64(1)})
+			So(<-typed, ShouldEqual, "unknown:b")
+			So(<-ack, ShouldEqual, 2)
+		})
+	})
+}
+
+// unwritableConn is the engine.io connection which is never writable, and fails the test if written.
+type unwritableConn struct {
+	engineio.Conn
+}
+
+func (unwritableConn) Id() string {
+	return "id"
+}
+
+func (unwritableConn) NextVolatileWriter(engineio.MessageType) (io.WriteCloser, error) {
+	return nil, engineio.NotWritableError
+}
+
+func TestSocketVolatile(t *testing.T) {
+	Convey("Volatile emits", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		sockets := make(chan Socket, 1)
+		server.On("connection", func(so Socket) {
+			sockets <- so
+		})
+		h := httptest.NewServer(server)
+		defer h.Close()
+
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true, Transports: []string{"websocket"}})
+		So(err, ShouldBeNil)
+		defer client.Close()
+		ticks := make(chan int, 2)
+		client.On("tick", func(n int) {
+			ticks <- n
+		})
+		client.On("echo", func(msg string) string {
+			return msg
+		})
+		so := <-sockets
+		// The ack comes once the packets before it are written, so nothing is pending.
+		_, err = so.EmitWithAck(context.Background(), "echo", "hi")
+		So(err, ShouldBeNil)
+
+		So(so.Volatile().Emit("tick", 1), ShouldBeNil)
+		So(<-ticks, ShouldEqual, 1)
+		So(server.Volatile().Emit("tick", 2), ShouldBeNil)
+		So(<-ticks, ShouldEqual, 2)
+	})
+
+	Convey("Drop when the connection isn't writable", t, func() {
+		so := newSocket(&conn{Conn: unwritableConn{}}, newNamespace(newBroadcastDefault()))
+		So(so.Volatile().Emit("tick", 1), ShouldBeNil)
+		So(so.Volatile().Emit("tick", 1, func(n int) {}), ShouldEqual, DroppedError)
+		acked := make(chan error, 1)
+		So(so.Volatile().Emit("tick", 1, func(err error, n int) { acked <- err }), ShouldBeNil)
+		So(<-acked, ShouldEqual, DroppedError)
+		_, err := so.Volatile().EmitWithAck(context.Background(), "tick", 1)
+		So(err, ShouldEqual, DroppedError)
+		So(len(so.acks), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,57 @@
+package socketio
+
+import (
+	"reflect"
+
+	"github.com/pschlump/json" //	"encoding/json"
+)
+
+// Registrar is what handlers are registered on: a Server, a Namespace or a Socket.
+type Registrar interface {
+	Handle(message string, f EventHandlerFunc) error
+}
+
+// ackRegistrar is the Registrar whose raw handlers can send acks back.
+type ackRegistrar interface {
+	handleAck(message string, f ackHandlerFunc) error
+}
+
+// OnEvent registers f to handle event, with the first arg of the event decoded straight into T, without
+// going through the reflection of On. What f returns is sent back if the client asks for an ack. Like
+// for On, an error closes the connection, and args which don't decode into T close it with an *ArgsError.
+func OnEvent[T, R any](h Registrar, event string, f func(so Socket, msg T) (R, error)) error {
+	return handleTyped(h, event, reflect.TypeOf(f), func(so Socket, msg T) ([]interface{}, error) {
+		r, err := f(so, msg)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{r}, nil
+	})
+}
+
+// OnEventNoReply is OnEvent for events which get no ack value back.
+func OnEventNoReply[T any](h Registrar, event string, f func(so Socket, msg T) error) error {
+	return handleTyped(h, event, reflect.TypeOf(f), func(so Socket, msg T) ([]interface{}, error) {
+		return nil, f(so, msg)
+	})
+}
+
+// handleTyped checks the types of the typed handler ft, and registers f as raw handler of event.
+func handleTyped[T any](h Registrar, event string, ft reflect.Type, f func(so Socket, msg T) ([]interface{}, error)) error {
+	if err := checkFunc(ft, false); err != nil {
+		return err
+	}
+	r, ok := h.(ackRegistrar)
+	if !ok {
+		return &HandlerError{Func: ft, Reason: "registrar can't send acks"}
+	}
+	return r.handleAck(event, func(so Socket, message string, raw []json.RawMessage, binary [][]byte) ([]interface{}, error) {
+		var msg T
+		if len(raw) > 0 {
+			if _, err := decodeRawArgs([]interface{}{&msg}, raw[:1], binary); err != nil {
+				return nil, &ArgsError{Event: message, Err: err}
+			}
+		}
+		return f(so, msg)
+	})
+}
@@ -0,0 +1,53 @@
+package socketio
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pschlump/json" //	"encoding/json"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type chatMessage struct {
+	Room string `json:"room"`
+	Text string `json:"text"`
+}
+
+func TestOnEvent(t *testing.T) {
+	Convey("Handle typed events", t, func() {
+		server, err := NewServer(nil)
+		So(err, ShouldBeNil)
+		So(OnEvent(server, "chat", func(so Socket, msg chatMessage) (int, error) {
+			if msg.Room == "" {
+				return 0, errors.New("no room")
+			}
+			return len(msg.Text), nil
+		}), ShouldBeNil)
+		got := make(chan *chatMessage, 1)
+		So(OnEventNoReply(server, "notice", func(so Socket, msg *chatMessage) error {
+			got <- msg
+			return nil
+		}), ShouldBeNil)
+		So(OnEvent(server, "bad", func(so Socket, msg chan int) (int, error) { return 0, nil }), ShouldHaveSameTypeAs, &HandlerError{})
+
+		_, err = server.x_events["chat"](nil, "chat", []json.RawMessage{json.RawMessage(`"text"`)}, nil)
+		So(err, ShouldHaveSameTypeAs, &ArgsError{})
+
+		h := httptest.NewServer(server)
+		defer h.Close()
+		client, err := Dial(h.URL, &ClientOptions{NoReconnect: true})
+		So(err, ShouldBeNil)
+		defer client.Close()
+
+		n := make(chan int, 1)
+		So(client.Emit("chat", chatMessage{Room: "a", Text: "hello"}, func(l int) {
+			n <- l
+		}), ShouldBeNil)
+		So(<-n, ShouldEqual, 5)
+
+		So(client.Emit("notice", chatMessage{Room: "a", Text: "hi"}), ShouldBeNil)
+		So(<-got, ShouldResemble, &chatMessage{Room: "a", Text: "hi"})
+	})
+}